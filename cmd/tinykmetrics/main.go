@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os/signal"
+	"syscall"
 
 	"github.com/stenstromen/tinykmetrics/internal/config"
 	"github.com/stenstromen/tinykmetrics/internal/handlers"
@@ -13,6 +17,10 @@ import (
 func main() {
 	cfg := config.ParseFlags()
 
+	// Cancelled on SIGINT/SIGTERM to start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	// Initialize services
 	var kubeService *services.KubernetesService
 	var err error
@@ -37,32 +45,86 @@ func main() {
 		}
 	}
 
-	influxService := services.NewInfluxDBService(
-		cfg.InfluxURL,
-		cfg.InfluxToken,
-		cfg.InfluxOrg,
-		cfg.InfluxBucket,
-	)
-	defer influxService.Client.Close()
+	filter, err := services.NewCollectionFilter(cfg.Filter.IncludeNamespaces, cfg.Filter.ExcludeNamespaces, cfg.Filter.PodSelector)
+	if err != nil {
+		log.Fatalf("Error creating collection filter: %v", err)
+	}
+	kubeService.SetFilter(filter)
+	kubeService.SetTagger(services.NewPodTagger(cfg.Tags.Labels, cfg.Tags.Annotations, cfg.Tags.MaxValues))
+	kubeService.SetSummaryOptions(summaryOptions(cfg))
+	kubeService.SetEventFilter(eventFilter(cfg))
+
+	// Initialize sinks
+	sinks, err := buildSinks(cfg, nil)
+	if err != nil {
+		log.Fatalf("Error creating sinks: %v", err)
+	}
+	sink := services.NewSwitchSink(sinks.sinks)
 
 	// Initialize handlers
-	h := handlers.NewHandlers(kubeService, influxService)
+	h := handlers.NewHandlers(kubeService, sinks.influxService, sinks.querier, sinks.exporter)
 
 	// Setup routes
 	mux := http.NewServeMux()
 	mux.Handle("/", http.FileServer(http.Dir("static")))
 	mux.HandleFunc("/api/metrics", h.HandleMetrics)
+	mux.HandleFunc("/api/metrics/nodes", h.HandleNodeMetrics)
+	mux.HandleFunc("/api/restarts", h.HandleRestarts)
+	mux.HandleFunc("/api/events", h.HandleEvents)
 	mux.HandleFunc("/api/namespaces", h.HandleNamespaces)
 	mux.HandleFunc("/api/pods", h.HandlePods)
+	mux.HandleFunc("/api/workloads", h.HandleWorkloads)
+	mux.HandleFunc("/api/nodes", h.HandleNodes)
 	mux.HandleFunc("/ready", h.HandleReadiness)
 	mux.HandleFunc("/status", h.HandleLiveness)
+	mux.HandleFunc("/metrics", h.HandlePrometheusMetrics)
+
+	// Start Kubernetes informers
+	kubeService.StartInformers(ctx)
 
 	// Start metrics collection
-	go kubeService.StartMetricsCollection(cfg.PollInterval, influxService)
+	go kubeService.StartMetricsCollection(ctx, cfg.PollInterval, sink)
+
+	// Apply configuration changes on SIGHUP and file changes
+	reload := &reloader{
+		cfg:         cfg,
+		sinks:       sinks,
+		sink:        sink,
+		kubeService: kubeService,
+		handlers:    h,
+		done:        make(chan struct{}),
+	}
+	go reload.run(ctx)
 
 	// Start server
-	log.Printf("Starting web server on %s", cfg.ListenAddr)
-	if err := http.ListenAndServe(cfg.ListenAddr, mux); err != nil {
-		log.Fatalf("Error starting web server: %v", err)
+	server := &http.Server{Addr: cfg.ListenAddr, Handler: mux}
+	go func() {
+		log.Printf("Starting web server on %s", cfg.ListenAddr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Error starting web server: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	<-reload.done
+	cfg = reload.cfg
+	log.Printf("Shutting down, waiting up to %v", cfg.ShutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down web server: %v", err)
+	}
+	if err := kubeService.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error waiting for metrics collection: %v", err)
 	}
+
+	// Sinks are closed only once the last write has returned
+	if err := sink.Close(); err != nil {
+		log.Printf("Error closing sinks: %v", err)
+	}
+
+	log.Println("Shutdown complete")
 }
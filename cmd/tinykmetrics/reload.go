@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/stenstromen/tinykmetrics/internal/config"
+	"github.com/stenstromen/tinykmetrics/internal/handlers"
+	"github.com/stenstromen/tinykmetrics/internal/services"
+)
+
+// reloader applies configuration changes while metrics are collected.
+// The poll interval, collection filter, tags, kubelet summaries, sinks and
+// their credentials change in place; the web server address, kubeconfig
+// and test mode need a restart.
+type reloader struct {
+	cfg         *config.Config
+	sinks       *sinkSet
+	sink        *services.SwitchSink
+	kubeService *services.KubernetesService
+	handlers    *handlers.Handlers
+	done        chan struct{}
+}
+
+// run reloads the configuration on SIGHUP and whenever a watched file
+// changes, until ctx is cancelled.
+func (r *reloader) run(ctx context.Context) {
+	defer close(r.done)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	watcher := config.NewWatcher(r.cfg.WatchFiles)
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	r.resetWatch(ticker)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Println("Received SIGHUP, reloading configuration")
+		case <-ticker.C:
+			if !watcher.Changed() {
+				continue
+			}
+			log.Println("Configuration files changed, reloading configuration")
+		}
+
+		if r.reload() {
+			watcher = config.NewWatcher(r.cfg.WatchFiles)
+			r.resetWatch(ticker)
+		}
+	}
+}
+
+// resetWatch applies the watch interval, stopping the ticker when file
+// watching is disabled.
+func (r *reloader) resetWatch(ticker *time.Ticker) {
+	if r.cfg.WatchInterval > 0 && len(r.cfg.WatchFiles) > 0 {
+		ticker.Reset(r.cfg.WatchInterval)
+	} else {
+		ticker.Stop()
+	}
+}
+
+// reload loads the configuration again and swaps in sinks built from it,
+// keeping the current configuration if it is invalid.
+func (r *reloader) reload() bool {
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Printf("Error reloading configuration, keeping the current one:\n%v", err)
+		return false
+	}
+
+	filter, err := services.NewCollectionFilter(cfg.Filter.IncludeNamespaces, cfg.Filter.ExcludeNamespaces, cfg.Filter.PodSelector)
+	if err != nil {
+		log.Printf("Error reloading configuration, keeping the current one: %v", err)
+		return false
+	}
+
+	next, err := buildSinks(cfg, r.sinks)
+	if err != nil {
+		log.Printf("Error reloading configuration, keeping the current one: %v", err)
+		return false
+	}
+
+	r.sink.Swap(next.sinks)
+	if err := closeReplaced(r.sinks, next); err != nil {
+		log.Printf("Error closing replaced sinks: %v", err)
+	}
+	r.handlers.SetBackends(next.influxService, next.querier, next.exporter)
+	r.kubeService.SetFilter(filter)
+	// A new tagger forgets which values are within the limits
+	if !reflect.DeepEqual(cfg.Tags, r.cfg.Tags) {
+		r.kubeService.SetTagger(services.NewPodTagger(cfg.Tags.Labels, cfg.Tags.Annotations, cfg.Tags.MaxValues))
+	}
+
+	r.kubeService.SetSummaryOptions(summaryOptions(cfg))
+	r.kubeService.SetEventFilter(eventFilter(cfg))
+
+	if cfg.PollInterval != r.cfg.PollInterval {
+		r.kubeService.SetInterval(cfg.PollInterval)
+	}
+	if cfg.ListenAddr != r.cfg.ListenAddr || cfg.KubeconfigPath != r.cfg.KubeconfigPath || cfg.TestMode != r.cfg.TestMode {
+		log.Println("Changes to --listen-addr, --kubeconfig and --test-mode take effect after a restart")
+	}
+
+	r.cfg, r.sinks = cfg, next
+	log.Printf("Configuration reloaded, writing to %v", cfg.Sinks)
+	return true
+}
+
+// summaryOptions returns the kubelet Summary API settings, or nil if it is
+// not used.
+func summaryOptions(cfg *config.Config) *services.SummaryOptions {
+	if !cfg.Summary.Enabled {
+		return nil
+	}
+	return &services.SummaryOptions{
+		URL:         cfg.Summary.URL,
+		Concurrency: cfg.Summary.Concurrency,
+		Timeout:     cfg.Summary.Timeout,
+	}
+}
+
+// eventFilter returns the Events to record, or nil if none are.
+func eventFilter(cfg *config.Config) *services.EventFilter {
+	if !cfg.Events.Enabled {
+		return nil
+	}
+	return services.NewEventFilter(cfg.Events.Types, cfg.Events.Reasons)
+}
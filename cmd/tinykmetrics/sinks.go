@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/stenstromen/tinykmetrics/internal/config"
+	"github.com/stenstromen/tinykmetrics/internal/services"
+)
+
+// sinkSet holds the sinks built from a configuration and the services the
+// handlers use from them.
+type sinkSet struct {
+	sinks         services.MultiSink
+	influxService *services.InfluxDBService // nil unless writing to InfluxDB
+	influx        influxSettings
+	exporter      *services.PrometheusExporter
+	memory        *services.MemoryStore
+	file          *services.FileSink
+	fileCfg       config.FileConfig
+	querier       services.Querier
+}
+
+// influxSettings are the options an InfluxDBService is built from.
+type influxSettings struct {
+	URL     string
+	Token   string
+	Org     string
+	Bucket  string
+	Version int
+	V1      config.InfluxV1Config
+	Write   config.InfluxWriteConfig
+	Spool   config.SpoolConfig
+}
+
+// buildSinks creates the sinks listed in cfg. Sinks holding data are
+// taken over from prev on reload: the Prometheus exporter and the memory
+// store always, and the InfluxDB service, with its spool and counters, and
+// the file sink when their settings are unchanged.
+func buildSinks(cfg *config.Config, prev *sinkSet) (_ *sinkSet, err error) {
+	set := &sinkSet{}
+	if prev == nil {
+		prev = &sinkSet{}
+	}
+	// Close what was created so far if a later sink fails
+	defer func() {
+		if err != nil {
+			closeReplaced(set, prev)
+		}
+	}()
+
+	for _, name := range cfg.Sinks {
+		switch name {
+		case "influxdb":
+			set.influx = influxSettings{
+				URL:     cfg.InfluxURL,
+				Token:   cfg.InfluxToken,
+				Org:     cfg.InfluxOrg,
+				Bucket:  cfg.InfluxBucket,
+				Version: cfg.InfluxVersion,
+				V1:      cfg.InfluxV1,
+				Write:   cfg.InfluxWrite,
+				Spool:   cfg.Spool,
+			}
+			if prev.influxService != nil && prev.influx == set.influx {
+				set.influxService = prev.influxService
+			} else {
+				influxService, err := newInfluxService(cfg)
+				if err != nil {
+					return nil, err
+				}
+				set.influxService = influxService
+			}
+			set.sinks = append(set.sinks, set.influxService)
+			if cfg.QueryBackend == "influxdb" {
+				set.querier = set.influxService
+			}
+		case "prometheus":
+			// Samples missing from three consecutive collections are stale
+			set.exporter = prev.exporter
+			if set.exporter == nil {
+				set.exporter = services.NewPrometheusExporter(3 * cfg.PollInterval)
+			} else {
+				set.exporter.SetStaleAfter(3 * cfg.PollInterval)
+			}
+			set.sinks = append(set.sinks, set.exporter)
+		case "remote-write":
+			remoteWrite, err := services.NewRemoteWriteSink(services.RemoteWriteOptions{
+				URL:         cfg.RemoteWrite.URL,
+				Headers:     cfg.RemoteWrite.Headers,
+				Username:    cfg.RemoteWrite.Username,
+				Password:    cfg.RemoteWrite.Password,
+				BearerToken: cfg.RemoteWrite.BearerToken,
+				TLS: services.TLSOptions{
+					CAFile:             cfg.RemoteWrite.CAFile,
+					CertFile:           cfg.RemoteWrite.CertFile,
+					KeyFile:            cfg.RemoteWrite.KeyFile,
+					InsecureSkipVerify: cfg.RemoteWrite.InsecureSkipVerify,
+				},
+				Timeout:       cfg.RemoteWrite.Timeout,
+				MaxRetries:    cfg.RemoteWrite.MaxRetries,
+				RetryInterval: cfg.RemoteWrite.RetryInterval,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("error creating remote-write sink: %v", err)
+			}
+			set.sinks = append(set.sinks, remoteWrite)
+		case "otlp":
+			otlp, err := services.NewOTLPSink(services.OTLPOptions{
+				Endpoint: cfg.OTLP.Endpoint,
+				Protocol: cfg.OTLP.Protocol,
+				Headers:  cfg.OTLP.Headers,
+				TLS: services.TLSOptions{
+					CAFile:             cfg.OTLP.CAFile,
+					CertFile:           cfg.OTLP.CertFile,
+					KeyFile:            cfg.OTLP.KeyFile,
+					InsecureSkipVerify: cfg.OTLP.InsecureSkipVerify,
+				},
+				Insecure:      cfg.OTLP.Insecure,
+				Timeout:       cfg.OTLP.Timeout,
+				MaxRetries:    cfg.OTLP.MaxRetries,
+				RetryInterval: cfg.OTLP.RetryInterval,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("error creating OTLP sink: %v", err)
+			}
+			set.sinks = append(set.sinks, otlp)
+		case "file":
+			set.fileCfg = cfg.File
+			if prev.file != nil && prev.fileCfg == set.fileCfg {
+				set.file = prev.file
+			} else {
+				file, err := services.NewFileSink(services.FileOptions{
+					Dir:      cfg.File.Dir,
+					Format:   cfg.File.Format,
+					MaxBytes: cfg.File.MaxBytes,
+					MaxAge:   cfg.File.MaxAge,
+					MaxFiles: cfg.File.MaxFiles,
+					Compress: cfg.File.Compress,
+				})
+				if err != nil {
+					return nil, fmt.Errorf("error creating file sink: %v", err)
+				}
+				set.file = file
+			}
+			set.sinks = append(set.sinks, set.file)
+		case "memory":
+			set.memory = prev.memory
+			if set.memory == nil {
+				set.memory = services.NewMemoryStore(cfg.MemoryRetention, cfg.PollInterval)
+			} else {
+				set.memory.Resize(cfg.MemoryRetention, cfg.PollInterval)
+			}
+			set.sinks = append(set.sinks, set.memory)
+			if cfg.QueryBackend == "memory" {
+				set.querier = set.memory
+			}
+		}
+	}
+
+	return set, nil
+}
+
+func newInfluxService(cfg *config.Config) (*services.InfluxDBService, error) {
+	writeOptions := services.WriteOptions{
+		BatchSize:     cfg.InfluxWrite.BatchSize,
+		Concurrency:   cfg.InfluxWrite.FlushConcurrency,
+		MaxRetries:    cfg.InfluxWrite.MaxRetries,
+		RetryInterval: cfg.InfluxWrite.RetryInterval,
+	}
+
+	var influxService *services.InfluxDBService
+	if cfg.InfluxVersion == 1 {
+		influxService = services.NewInfluxDBV1Service(
+			cfg.InfluxURL,
+			cfg.InfluxV1.Database,
+			cfg.InfluxV1.RetentionPolicy,
+			cfg.InfluxV1.Username,
+			cfg.InfluxV1.Password,
+			writeOptions,
+		)
+	} else {
+		influxService = services.NewInfluxDBService(
+			cfg.InfluxURL,
+			cfg.InfluxToken,
+			cfg.InfluxOrg,
+			cfg.InfluxBucket,
+			writeOptions,
+		)
+	}
+
+	if cfg.Spool.Dir != "" {
+		spool, err := services.NewSpool(cfg.Spool.Dir, cfg.Spool.MaxBytes, cfg.Spool.MaxAge)
+		if err != nil {
+			influxService.Close()
+			return nil, fmt.Errorf("error creating spool: %v", err)
+		}
+		influxService.Spool = spool
+	}
+
+	return influxService, nil
+}
+
+// closeReplaced closes the sinks of old that are not part of next.
+func closeReplaced(old, next *sinkSet) error {
+	var errs []error
+	for _, sink := range old.sinks {
+		kept := false
+		for _, s := range next.sinks {
+			if s == sink {
+				kept = true
+				break
+			}
+		}
+		if !kept {
+			if err := sink.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
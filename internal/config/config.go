@@ -1,37 +1,683 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+// envPrefix prefixes the environment variables overriding flags, e.g.
+// TINYKMETRICS_INFLUX_TOKEN for --influx-token.
+const envPrefix = "TINYKMETRICS_"
+
 type Config struct {
-	InfluxURL      string
-	InfluxToken    string
-	InfluxOrg      string
-	InfluxBucket   string
-	KubeconfigPath string
-	PollInterval   time.Duration
-	ListenAddr     string
-	TestMode       bool
+	ConfigFile      string
+	WatchInterval   time.Duration
+	WatchFiles      []string // config and secret files reloaded on change
+	InfluxURL       string
+	InfluxToken     string
+	InfluxOrg       string
+	InfluxBucket    string
+	InfluxVersion   int
+	InfluxV1        InfluxV1Config
+	InfluxWrite     InfluxWriteConfig
+	Spool           SpoolConfig
+	RemoteWrite     RemoteWriteConfig
+	OTLP            OTLPConfig
+	File            FileConfig
+	MemoryRetention time.Duration
+	QueryBackend    string
+	Filter          FilterConfig
+	Tags            TagConfig
+	Summary         SummaryConfig
+	Events          EventConfig
+	KubeconfigPath  string
+	PollInterval    time.Duration
+	ListenAddr      string
+	ShutdownTimeout time.Duration
+	TestMode        bool
+	Sinks           []string
+}
+
+type InfluxV1Config struct {
+	Database        string
+	RetentionPolicy string
+	Username        string
+	Password        string
+}
+
+type InfluxWriteConfig struct {
+	BatchSize        int
+	FlushConcurrency int
+	MaxRetries       int
+	RetryInterval    time.Duration
+}
+
+type SpoolConfig struct {
+	Dir      string
+	MaxBytes int64
+	MaxAge   time.Duration
+}
+
+type RemoteWriteConfig struct {
+	URL                string
+	Headers            map[string]string
+	Username           string
+	Password           string
+	BearerToken        string
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+	MaxRetries         int
+	RetryInterval      time.Duration
+}
+
+type OTLPConfig struct {
+	Endpoint           string
+	Protocol           string
+	Headers            map[string]string
+	Insecure           bool
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+	MaxRetries         int
+	RetryInterval      time.Duration
+}
+
+// FilterConfig selects the namespaces and pods metrics are collected for.
+type FilterConfig struct {
+	IncludeNamespaces []string
+	ExcludeNamespaces []string
+	PodSelector       string
 }
 
+// TagConfig lists the pod labels and annotations written as tags, keyed
+// by label or annotation key with the tag name as value.
+type TagConfig struct {
+	Labels      map[string]string
+	Annotations map[string]string
+	MaxValues   int
+}
+
+// SummaryConfig enables collection from the kubelet Summary API.
+type SummaryConfig struct {
+	Enabled     bool
+	URL         string
+	Concurrency int
+	Timeout     time.Duration
+}
+
+// EventConfig enables recording Kubernetes Events, limited to the listed
+// types and reasons when set.
+type EventConfig struct {
+	Enabled bool
+	Types   []string
+	Reasons []string
+}
+
+type FileConfig struct {
+	Dir      string
+	Format   string
+	MaxBytes int64
+	MaxAge   time.Duration
+	MaxFiles int
+	Compress bool
+}
+
+// rawFlags holds flag values that are parsed further into Config.
+type rawFlags struct {
+	sinks                      string
+	includeNamespaces          string
+	excludeNamespaces          string
+	podLabelTags               string
+	podAnnotationTags          string
+	eventTypes                 string
+	eventReasons               string
+	remoteWriteHeaders         headersFlag
+	otlpHeaders                headersFlag
+	influxTokenFile            string
+	influxPasswordFile         string
+	remoteWritePasswordFile    string
+	remoteWriteBearerTokenFile string
+}
+
+// ParseFlags loads the configuration from the command line, exiting on
+// invalid configuration.
 func ParseFlags() *Config {
+	cfg, err := Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	return cfg
+}
+
+// Load builds the configuration from, in increasing order of precedence,
+// the flag defaults, the config file given with --config, TINYKMETRICS_*
+// environment variables and the flags in args. Config file keys are the
+// flag names. All problems found are reported together.
+func Load(args []string) (*Config, error) {
 	cfg := &Config{}
-	flag.StringVar(&cfg.InfluxURL, "influx-url", "http://localhost:8086", "InfluxDB URL")
-	flag.StringVar(&cfg.InfluxToken, "influx-token", "", "InfluxDB authentication token")
-	flag.StringVar(&cfg.InfluxOrg, "influx-org", "default", "InfluxDB organization")
-	flag.StringVar(&cfg.InfluxBucket, "influx-bucket", "k8s", "InfluxDB bucket")
-	flag.StringVar(&cfg.KubeconfigPath, "kubeconfig", "", "Path to kubeconfig file")
-	flag.DurationVar(&cfg.PollInterval, "interval", 30*time.Second, "Metrics collection interval")
-	flag.StringVar(&cfg.ListenAddr, "listen-addr", ":8080", "Web server listen address")
-	flag.BoolVar(&cfg.TestMode, "test-mode", false, "Start in test mode with mock data for first metric collection")
-	flag.Parse()
+	raw := &rawFlags{}
+
+	fs := flag.NewFlagSet("tinykmetrics", flag.ContinueOnError)
+	fs.StringVar(&cfg.ConfigFile, "config", "", "Path to a YAML or TOML config file")
+	fs.DurationVar(&cfg.WatchInterval, "config-watch-interval", 10*time.Second, "How often the config and secret files are checked for changes (0 disables)")
+	fs.StringVar(&cfg.InfluxURL, "influx-url", "http://localhost:8086", "InfluxDB URL")
+	fs.StringVar(&cfg.InfluxToken, "influx-token", "", "InfluxDB authentication token")
+	fs.StringVar(&raw.influxTokenFile, "influx-token-file", "", "File containing the InfluxDB authentication token")
+	fs.StringVar(&cfg.InfluxOrg, "influx-org", "default", "InfluxDB organization")
+	fs.StringVar(&cfg.InfluxBucket, "influx-bucket", "k8s", "InfluxDB bucket")
+	fs.IntVar(&cfg.InfluxVersion, "influx-version", 2, "InfluxDB API version (1 for InfluxDB 1.x and Telegraf listeners, 2)")
+	fs.StringVar(&cfg.InfluxV1.Database, "influx-database", "k8s", "InfluxDB 1.x database")
+	fs.StringVar(&cfg.InfluxV1.RetentionPolicy, "influx-retention-policy", "", "InfluxDB 1.x retention policy (database default if empty)")
+	fs.StringVar(&cfg.InfluxV1.Username, "influx-username", "", "InfluxDB 1.x username")
+	fs.StringVar(&cfg.InfluxV1.Password, "influx-password", "", "InfluxDB 1.x password")
+	fs.StringVar(&raw.influxPasswordFile, "influx-password-file", "", "File containing the InfluxDB 1.x password")
+	fs.IntVar(&cfg.InfluxWrite.BatchSize, "influx-batch-size", 5000, "Maximum number of points per InfluxDB write request")
+	fs.IntVar(&cfg.InfluxWrite.FlushConcurrency, "influx-flush-concurrency", 4, "Number of InfluxDB write requests flushed in parallel")
+	fs.IntVar(&cfg.InfluxWrite.MaxRetries, "influx-max-retries", 3, "Maximum retries for a failed InfluxDB write request")
+	fs.DurationVar(&cfg.InfluxWrite.RetryInterval, "influx-retry-interval", time.Second, "Initial backoff between InfluxDB write retries")
+	fs.StringVar(&cfg.Spool.Dir, "spool-dir", "", "Directory for buffering points while InfluxDB is unavailable (disabled if empty)")
+	fs.Int64Var(&cfg.Spool.MaxBytes, "spool-max-bytes", 100*1024*1024, "Maximum size of the spool directory in bytes")
+	fs.DurationVar(&cfg.Spool.MaxAge, "spool-max-age", 24*time.Hour, "Maximum age of spooled points before they are dropped")
+	fs.StringVar(&cfg.RemoteWrite.URL, "remote-write-url", "", "Prometheus remote-write endpoint URL")
+	fs.Var(&raw.remoteWriteHeaders, "remote-write-headers", "Comma-separated list of key=value headers sent with remote-write requests")
+	fs.StringVar(&cfg.RemoteWrite.Username, "remote-write-username", "", "Username for remote-write basic authentication")
+	fs.StringVar(&cfg.RemoteWrite.Password, "remote-write-password", "", "Password for remote-write basic authentication")
+	fs.StringVar(&raw.remoteWritePasswordFile, "remote-write-password-file", "", "File containing the remote-write basic authentication password")
+	fs.StringVar(&cfg.RemoteWrite.BearerToken, "remote-write-bearer-token", "", "Bearer token for remote-write requests")
+	fs.StringVar(&raw.remoteWriteBearerTokenFile, "remote-write-bearer-token-file", "", "File containing the bearer token for remote-write requests")
+	fs.StringVar(&cfg.RemoteWrite.CAFile, "remote-write-ca-file", "", "CA certificate file for verifying the remote-write endpoint")
+	fs.StringVar(&cfg.RemoteWrite.CertFile, "remote-write-cert-file", "", "Client certificate file for remote-write requests")
+	fs.StringVar(&cfg.RemoteWrite.KeyFile, "remote-write-key-file", "", "Client key file for remote-write requests")
+	fs.BoolVar(&cfg.RemoteWrite.InsecureSkipVerify, "remote-write-insecure-skip-verify", false, "Skip TLS verification of the remote-write endpoint")
+	fs.DurationVar(&cfg.RemoteWrite.Timeout, "remote-write-timeout", 30*time.Second, "Timeout for a single remote-write request")
+	fs.IntVar(&cfg.RemoteWrite.MaxRetries, "remote-write-max-retries", 3, "Maximum retries for a failed remote-write request")
+	fs.DurationVar(&cfg.RemoteWrite.RetryInterval, "remote-write-retry-interval", time.Second, "Initial backoff between remote-write retries")
+	fs.StringVar(&cfg.OTLP.Endpoint, "otlp-endpoint", "", "OTLP endpoint, host:port for grpc or a URL for http/protobuf (default localhost:4317 or http://localhost:4318)")
+	fs.StringVar(&cfg.OTLP.Protocol, "otlp-protocol", "grpc", "OTLP protocol (grpc, http/protobuf)")
+	fs.Var(&raw.otlpHeaders, "otlp-headers", "Comma-separated list of key=value headers sent with OTLP requests")
+	fs.BoolVar(&cfg.OTLP.Insecure, "otlp-insecure", false, "Connect to the OTLP gRPC endpoint without TLS")
+	fs.StringVar(&cfg.OTLP.CAFile, "otlp-ca-file", "", "CA certificate file for verifying the OTLP endpoint")
+	fs.StringVar(&cfg.OTLP.CertFile, "otlp-cert-file", "", "Client certificate file for OTLP requests")
+	fs.StringVar(&cfg.OTLP.KeyFile, "otlp-key-file", "", "Client key file for OTLP requests")
+	fs.BoolVar(&cfg.OTLP.InsecureSkipVerify, "otlp-insecure-skip-verify", false, "Skip TLS verification of the OTLP endpoint")
+	fs.DurationVar(&cfg.OTLP.Timeout, "otlp-timeout", 10*time.Second, "Timeout for a single OTLP export request")
+	fs.IntVar(&cfg.OTLP.MaxRetries, "otlp-max-retries", 3, "Maximum retries for a failed OTLP export request")
+	fs.DurationVar(&cfg.OTLP.RetryInterval, "otlp-retry-interval", time.Second, "Initial backoff between OTLP export retries")
+	fs.StringVar(&cfg.File.Dir, "file-dir", "metrics", "Directory the file sink writes to")
+	fs.StringVar(&cfg.File.Format, "file-format", "ndjson", "File sink format (ndjson, line-protocol)")
+	fs.Int64Var(&cfg.File.MaxBytes, "file-max-bytes", 100*1024*1024, "Size in bytes at which the file sink rotates its file (0 disables)")
+	fs.DurationVar(&cfg.File.MaxAge, "file-max-age", time.Hour, "Age at which the file sink rotates its file (0 disables)")
+	fs.IntVar(&cfg.File.MaxFiles, "file-max-files", 0, "Number of rotated files to keep (0 keeps all)")
+	fs.BoolVar(&cfg.File.Compress, "file-compress", true, "Gzip rotated files")
+	fs.DurationVar(&cfg.MemoryRetention, "memory-retention", time.Hour, "How long the memory sink keeps samples")
+	fs.StringVar(&cfg.QueryBackend, "query-backend", "", "Sink answering /api/metrics queries (influxdb, memory); defaults to influxdb if it is a sink, otherwise memory")
+	fs.StringVar(&raw.includeNamespaces, "include-namespaces", "", "Comma-separated list of namespaces to collect, glob patterns allowed (all if empty)")
+	fs.StringVar(&raw.excludeNamespaces, "exclude-namespaces", "", "Comma-separated list of namespaces not to collect, glob patterns allowed")
+	fs.StringVar(&cfg.Filter.PodSelector, "pod-selector", "", "Label selector of the pods to collect, e.g. app=web,tier!=cache")
+	fs.StringVar(&raw.podLabelTags, "pod-label-tags", "", "Comma-separated list of pod labels written as tags, as label or label=tag")
+	fs.StringVar(&raw.podAnnotationTags, "pod-annotation-tags", "", "Comma-separated list of pod annotations written as tags, as annotation or annotation=tag")
+	fs.IntVar(&cfg.Tags.MaxValues, "tag-max-values", 100, "Maximum number of values per label or annotation tag, further values are written as _other (0 disables)")
+	fs.BoolVar(&cfg.Summary.Enabled, "kubelet-summary", false, "Collect network, filesystem and volume statistics from the kubelet Summary API")
+	fs.StringVar(&cfg.Summary.URL, "summary-url", "", "URL of the kubelet summaries, {node} is replaced by the node name (default through the API server node proxy)")
+	fs.IntVar(&cfg.Summary.Concurrency, "summary-concurrency", 5, "Number of kubelet summaries read in parallel")
+	fs.DurationVar(&cfg.Summary.Timeout, "summary-timeout", 10*time.Second, "Timeout for reading a kubelet summary")
+	fs.BoolVar(&cfg.Events.Enabled, "events", false, "Record Kubernetes Events in the events measurement")
+	fs.StringVar(&raw.eventTypes, "event-types", "", "Comma-separated list of event types to record (Normal, Warning; all if empty)")
+	fs.StringVar(&raw.eventReasons, "event-reasons", "", "Comma-separated list of event reasons to record, e.g. FailedScheduling,Evicted (all if empty)")
+	fs.StringVar(&cfg.KubeconfigPath, "kubeconfig", "", "Path to kubeconfig file")
+	fs.DurationVar(&cfg.PollInterval, "interval", 30*time.Second, "Metrics collection interval")
+	fs.StringVar(&cfg.ListenAddr, "listen-addr", ":8080", "Web server listen address")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 25*time.Second, "Time allowed for in-flight requests and metrics collection to finish on shutdown")
+	fs.BoolVar(&cfg.TestMode, "test-mode", false, "Start in test mode with mock data for first metric collection")
+	fs.StringVar(&raw.sinks, "sinks", "influxdb", "Comma-separated list of sinks to write metrics to (influxdb, prometheus, remote-write, otlp, file, memory)")
 
-	if cfg.InfluxToken == "" {
-		log.Fatal("InfluxDB token is required. Please provide it using --influx-token flag")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
 	}
 
-	return cfg
+	var errs []error
+	if cfg.ConfigFile == "" {
+		cfg.ConfigFile = os.Getenv(envPrefix + "CONFIG")
+	}
+	if cfg.ConfigFile != "" {
+		cfg.WatchFiles = append(cfg.WatchFiles, cfg.ConfigFile)
+		errs = append(errs, applyFile(fs, cfg.ConfigFile)...)
+	}
+	errs = append(errs, applyEnv(fs)...)
+
+	// Flags on the command line win over the file and the environment
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	for _, secret := range []struct {
+		flag  string
+		value *string
+		file  string
+	}{
+		{"influx-token", &cfg.InfluxToken, raw.influxTokenFile},
+		{"influx-password", &cfg.InfluxV1.Password, raw.influxPasswordFile},
+		{"remote-write-password", &cfg.RemoteWrite.Password, raw.remoteWritePasswordFile},
+		{"remote-write-bearer-token", &cfg.RemoteWrite.BearerToken, raw.remoteWriteBearerTokenFile},
+	} {
+		if secret.file == "" {
+			continue
+		}
+		cfg.WatchFiles = append(cfg.WatchFiles, secret.file)
+		if *secret.value != "" {
+			errs = append(errs, fmt.Errorf("--%s and --%s-file are mutually exclusive", secret.flag, secret.flag))
+			continue
+		}
+		data, err := os.ReadFile(secret.file)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error reading --%s-file: %v", secret.flag, err))
+			continue
+		}
+		*secret.value = strings.TrimSpace(string(data))
+	}
+
+	if cfg.InfluxWrite.BatchSize <= 0 {
+		errs = append(errs, errors.New("--influx-batch-size must be greater than zero"))
+	}
+	if cfg.InfluxWrite.FlushConcurrency <= 0 {
+		errs = append(errs, errors.New("--influx-flush-concurrency must be greater than zero"))
+	}
+	if cfg.InfluxWrite.MaxRetries < 0 {
+		errs = append(errs, errors.New("--influx-max-retries must not be negative"))
+	}
+	if cfg.RemoteWrite.MaxRetries < 0 {
+		errs = append(errs, errors.New("--remote-write-max-retries must not be negative"))
+	}
+	if cfg.OTLP.MaxRetries < 0 {
+		errs = append(errs, errors.New("--otlp-max-retries must not be negative"))
+	}
+
+	for _, name := range strings.Split(raw.sinks, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "":
+			continue
+		case "influxdb", "prometheus", "remote-write", "otlp", "file", "memory":
+			cfg.Sinks = append(cfg.Sinks, name)
+		default:
+			errs = append(errs, fmt.Errorf("Unknown sink %q. Supported sinks: influxdb, prometheus, remote-write, otlp, file, memory", name))
+		}
+	}
+	if len(cfg.Sinks) == 0 {
+		errs = append(errs, errors.New("At least one sink is required. Please provide it using --sinks flag"))
+	}
+
+	for _, list := range []struct {
+		flag     string
+		value    string
+		patterns *[]string
+	}{
+		{"include-namespaces", raw.includeNamespaces, &cfg.Filter.IncludeNamespaces},
+		{"exclude-namespaces", raw.excludeNamespaces, &cfg.Filter.ExcludeNamespaces},
+	} {
+		for _, pattern := range strings.Split(list.value, ",") {
+			pattern = strings.TrimSpace(pattern)
+			if pattern == "" {
+				continue
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("Invalid --%s pattern %q: %v", list.flag, pattern, err))
+				continue
+			}
+			*list.patterns = append(*list.patterns, pattern)
+		}
+	}
+	if _, err := labels.Parse(cfg.Filter.PodSelector); err != nil {
+		errs = append(errs, fmt.Errorf("Invalid --pod-selector: %v", err))
+	}
+
+	var err error
+	tagNames := map[string]bool{}
+	for _, tag := range reservedTagNames {
+		tagNames[tag] = true
+	}
+	if cfg.Tags.Labels, err = parseTags("pod-label-tags", raw.podLabelTags, tagNames); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Tags.Annotations, err = parseTags("pod-annotation-tags", raw.podAnnotationTags, tagNames); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Tags.MaxValues < 0 {
+		errs = append(errs, errors.New("--tag-max-values must not be negative"))
+	}
+
+	if cfg.Summary.Enabled {
+		if cfg.Summary.Concurrency <= 0 {
+			errs = append(errs, errors.New("--summary-concurrency must be greater than zero"))
+		}
+		if cfg.Summary.URL != "" {
+			if u, err := url.Parse(strings.ReplaceAll(cfg.Summary.URL, "{node}", "node")); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				errs = append(errs, fmt.Errorf("Invalid --summary-url %q, expected an http or https URL", cfg.Summary.URL))
+			}
+		}
+	}
+
+	for _, eventType := range strings.Split(raw.eventTypes, ",") {
+		switch eventType = strings.TrimSpace(eventType); eventType {
+		case "":
+			continue
+		case "Normal", "Warning":
+			cfg.Events.Types = append(cfg.Events.Types, eventType)
+		default:
+			errs = append(errs, fmt.Errorf("Unknown event type %q. Supported types: Normal, Warning", eventType))
+		}
+	}
+	for _, reason := range strings.Split(raw.eventReasons, ",") {
+		if reason = strings.TrimSpace(reason); reason != "" {
+			cfg.Events.Reasons = append(cfg.Events.Reasons, reason)
+		}
+	}
+
+	switch cfg.QueryBackend {
+	case "":
+		if slices.Contains(cfg.Sinks, "influxdb") {
+			cfg.QueryBackend = "influxdb"
+		} else if slices.Contains(cfg.Sinks, "memory") {
+			cfg.QueryBackend = "memory"
+		}
+	case "influxdb", "memory":
+		if !slices.Contains(cfg.Sinks, cfg.QueryBackend) {
+			errs = append(errs, fmt.Errorf("Query backend %q must also be listed in --sinks", cfg.QueryBackend))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("Unknown query backend %q. Supported backends: influxdb, memory", cfg.QueryBackend))
+	}
+	if slices.Contains(cfg.Sinks, "memory") && cfg.MemoryRetention <= 0 {
+		errs = append(errs, errors.New("--memory-retention must be greater than zero"))
+	}
+
+	if slices.Contains(cfg.Sinks, "remote-write") && cfg.RemoteWrite.URL == "" {
+		errs = append(errs, errors.New("Remote-write URL is required. Please provide it using --remote-write-url flag"))
+	}
+	if cfg.RemoteWrite.Username != "" && cfg.RemoteWrite.BearerToken != "" {
+		errs = append(errs, errors.New("--remote-write-username and --remote-write-bearer-token are mutually exclusive"))
+	}
+
+	if cfg.RemoteWrite.Headers, err = parseHeaders("remote-write", raw.remoteWriteHeaders); err != nil {
+		errs = append(errs, err)
+	}
+
+	switch cfg.OTLP.Protocol {
+	case "grpc":
+		if cfg.OTLP.Endpoint == "" {
+			cfg.OTLP.Endpoint = "localhost:4317"
+		}
+	case "http/protobuf":
+		if cfg.OTLP.Endpoint == "" {
+			cfg.OTLP.Endpoint = "http://localhost:4318"
+		}
+	default:
+		errs = append(errs, fmt.Errorf("Unknown OTLP protocol %q. Supported protocols: grpc, http/protobuf", cfg.OTLP.Protocol))
+	}
+	if cfg.OTLP.Headers, err = parseHeaders("OTLP", raw.otlpHeaders); err != nil {
+		errs = append(errs, err)
+	}
+
+	if slices.Contains(cfg.Sinks, "file") {
+		if cfg.File.Format != "ndjson" && cfg.File.Format != "line-protocol" {
+			errs = append(errs, fmt.Errorf("Unknown file format %q. Supported formats: ndjson, line-protocol", cfg.File.Format))
+		}
+		if cfg.File.Dir == "" {
+			errs = append(errs, errors.New("File sink directory is required. Please provide it using --file-dir flag"))
+		}
+	}
+
+	// InfluxDB settings only matter when writing to it
+	if slices.Contains(cfg.Sinks, "influxdb") {
+		switch cfg.InfluxVersion {
+		case 1:
+			if cfg.InfluxV1.Database == "" {
+				errs = append(errs, errors.New("InfluxDB database is required. Please provide it using --influx-database flag"))
+			}
+		case 2:
+			if cfg.InfluxToken == "" {
+				errs = append(errs, errors.New("InfluxDB token is required. Please provide it using --influx-token, --influx-token-file or TINYKMETRICS_INFLUX_TOKEN"))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("Unsupported InfluxDB version %d. Supported versions: 1, 2", cfg.InfluxVersion))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyFile sets the flags named by the keys of a YAML or TOML file. Lists
+// are joined with commas and maps become comma-separated key=value pairs.
+func applyFile(fs *flag.FlagSet, path string) []error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []error{fmt.Errorf("error reading config file: %v", err)}
+	}
+
+	values := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		err = yaml.Unmarshal(data, &values)
+	}
+	if err != nil {
+		return []error{fmt.Errorf("error parsing config file %s: %v", path, err)}
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		if key == "config" || fs.Lookup(key) == nil {
+			errs = append(errs, fmt.Errorf("%s: unknown key %q", path, key))
+			continue
+		}
+		if m, ok := values[key].(map[string]interface{}); ok {
+			if headers, ok := fs.Lookup(key).Value.(*headersFlag); ok {
+				if err := headers.setMap(m); err != nil {
+					errs = append(errs, fmt.Errorf("%s: invalid value for %q: %v", path, key, err))
+				}
+				continue
+			}
+		}
+		value, err := fileValue(values[key])
+		if err == nil {
+			err = fs.Set(key, value)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid value for %q: %v", path, key, err))
+		}
+	}
+	return errs
+}
+
+func fileValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := fileValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]interface{}:
+		items := make([]string, 0, len(v))
+		for key, item := range v {
+			s, err := fileValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, key+"="+s)
+		}
+		sort.Strings(items)
+		return strings.Join(items, ","), nil
+	case string, bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", v)
+	}
+}
+
+// applyEnv sets every flag that has a matching TINYKMETRICS_ environment
+// variable.
+func applyEnv(fs *flag.FlagSet) []error {
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(name); ok {
+			if err := fs.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid value for %s: %v", name, err))
+			}
+		}
+	})
+	return errs
+}
+
+// reservedTagNames are the tag and label names tinykmetrics writes itself,
+// in any measurement or metric family, and "time", which InfluxDB
+// reserves. Names starting with an underscore are reserved as well.
+var reservedTagNames = []string{
+	"namespace", "pod", "container", "workload_kind", "workload_name",
+	"node", "role", "zone", "instance_type", "condition",
+	"volume", "persistentvolumeclaim", "phase", "qos_class",
+	"kind", "name", "type", "reason", "time",
+}
+
+// tagNamePattern matches tag names that are also valid Prometheus label
+// names.
+var tagNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// parseTags parses a comma-separated list of label or annotation keys,
+// each optionally followed by =tag. Without a tag name, the key is used
+// with every character that is not a letter, digit or underscore replaced
+// by an underscore. Tag names already in used are rejected and added to
+// used.
+func parseTags(name, value string, used map[string]bool) (map[string]string, error) {
+	tags := map[string]string{}
+	var errs []error
+	for _, item := range strings.Split(value, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		key, tag, ok := strings.Cut(item, "=")
+		key, tag = strings.TrimSpace(key), strings.TrimSpace(tag)
+		if !ok {
+			tag = invalidTagChars.ReplaceAllString(key, "_")
+		}
+		if msgs := validation.IsQualifiedName(key); len(msgs) > 0 {
+			errs = append(errs, fmt.Errorf("Invalid --%s key %q: %s", name, key, strings.Join(msgs, "; ")))
+			continue
+		}
+		if !tagNamePattern.MatchString(tag) {
+			errs = append(errs, fmt.Errorf("Invalid --%s tag name %q, expected letters, digits and underscores", name, tag))
+			continue
+		}
+		if used[tag] || strings.HasPrefix(tag, "_") {
+			errs = append(errs, fmt.Errorf("Tag name %q of --%s is reserved or already used", tag, name))
+			continue
+		}
+		used[tag] = true
+		tags[key] = tag
+	}
+	return tags, errors.Join(errs...)
+}
+
+// invalidTagChars matches the characters replaced in derived tag names.
+var invalidTagChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// headersFlag holds a header flag, set either from a comma-separated list
+// of key=value headers or, in the config file, from a map whose values may
+// contain commas.
+type headersFlag struct {
+	list    string
+	headers map[string]string
+}
+
+func (h *headersFlag) String() string { return h.list }
+
+func (h *headersFlag) Set(value string) error {
+	h.list, h.headers = value, nil
+	return nil
+}
+
+// setMap sets the headers from a config file map.
+func (h *headersFlag) setMap(m map[string]interface{}) error {
+	headers := make(map[string]string, len(m))
+	for key, item := range m {
+		value, err := fileValue(item)
+		if err != nil {
+			return err
+		}
+		headers[key] = value
+	}
+	h.list, h.headers = "", headers
+	return nil
+}
+
+// parseHeaders parses a header flag, reporting every invalid header.
+func parseHeaders(name string, flag headersFlag) (map[string]string, error) {
+	headers := map[string]string{}
+	var errs []error
+	if flag.headers != nil {
+		for _, key := range slices.Sorted(maps.Keys(flag.headers)) {
+			value := flag.headers[key]
+			if strings.TrimSpace(key) == "" {
+				errs = append(errs, fmt.Errorf("Invalid %s header %q, expected a header name", name, key+"="+value))
+				continue
+			}
+			headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+		return headers, errors.Join(errs...)
+	}
+
+	for _, header := range strings.Split(flag.list, ",") {
+		if strings.TrimSpace(header) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(header, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			errs = append(errs, fmt.Errorf("Invalid %s header %q, expected key=value", name, header))
+			continue
+		}
+		headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return headers, errors.Join(errs...)
 }
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"os"
+	"time"
+)
+
+// Watcher detects changes to files by polling their modification time and
+// size, which also catches the symlink swaps Kubernetes uses to update
+// mounted ConfigMaps and Secrets.
+type Watcher struct {
+	paths []string
+	state map[string]fileState
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+func NewWatcher(paths []string) *Watcher {
+	w := &Watcher{paths: paths}
+	w.state = w.stat()
+	return w
+}
+
+// Changed reports whether any file changed since the previous call.
+func (w *Watcher) Changed() bool {
+	state := w.stat()
+	changed := false
+	for path, s := range state {
+		if w.state[path] != s {
+			changed = true
+		}
+	}
+	w.state = state
+	return changed
+}
+
+func (w *Watcher) stat() map[string]fileState {
+	state := make(map[string]fileState, len(w.paths))
+	for _, path := range w.paths {
+		if info, err := os.Stat(path); err == nil {
+			state[path] = fileState{modTime: info.ModTime(), size: info.Size(), exists: true}
+		} else {
+			state[path] = fileState{}
+		}
+	}
+	return state
+}
@@ -3,31 +3,61 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"sync"
 
 	"github.com/stenstromen/tinykmetrics/internal/models"
 	"github.com/stenstromen/tinykmetrics/internal/services"
 )
 
 type Handlers struct {
-	kubeService   *services.KubernetesService
+	kubeService *services.KubernetesService
+
+	// Replaced on configuration reload
+	mu            sync.RWMutex
 	influxService *services.InfluxDBService
+	querier       services.Querier
+	exporter      *services.PrometheusExporter
 }
 
-func NewHandlers(k *services.KubernetesService, i *services.InfluxDBService) *Handlers {
+func NewHandlers(k *services.KubernetesService, i *services.InfluxDBService, q services.Querier, e *services.PrometheusExporter) *Handlers {
 	return &Handlers{
 		kubeService:   k,
 		influxService: i,
+		querier:       q,
+		exporter:      e,
 	}
 }
 
+// SetBackends replaces the services built from the configuration.
+func (h *Handlers) SetBackends(i *services.InfluxDBService, q services.Querier, e *services.PrometheusExporter) {
+	h.mu.Lock()
+	h.influxService, h.querier, h.exporter = i, q, e
+	h.mu.Unlock()
+}
+
+func (h *Handlers) backends() (*services.InfluxDBService, services.Querier, *services.PrometheusExporter) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.influxService, h.querier, h.exporter
+}
+
 func (h *Handlers) HandleReadiness(w http.ResponseWriter, r *http.Request) {
 	status := models.HealthStatus{
-		InfluxDB: h.influxService.CheckHealth(),
+		Cache: h.kubeService.HasSynced(),
+	}
+	healthy := status.Cache
+
+	if influxService, _, _ := h.backends(); influxService != nil {
+		influxDB := influxService.CheckHealth()
+		stats := influxService.Stats()
+		status.InfluxDB = &influxDB
+		status.Writes = &stats
+		healthy = healthy && influxDB
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 
-	if status.InfluxDB {
+	if healthy {
 		status.Status = "healthy"
 		w.WriteHeader(http.StatusOK)
 	} else {
@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/stenstromen/tinykmetrics/internal/models"
+	"github.com/stenstromen/tinykmetrics/internal/services"
 )
 
 func (h *Handlers) HandleNamespaces(w http.ResponseWriter, r *http.Request) {
@@ -14,6 +16,10 @@ func (h *Handlers) HandleNamespaces(w http.ResponseWriter, r *http.Request) {
 	}
 
 	namespaces, err := h.kubeService.ListNamespaces(r.Context())
+	if errors.Is(err, services.ErrCacheNotSynced) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -31,6 +37,10 @@ func (h *Handlers) HandlePods(w http.ResponseWriter, r *http.Request) {
 
 	namespace := r.URL.Query().Get("namespace")
 	pods, err := h.kubeService.ListPods(r.Context(), namespace)
+	if errors.Is(err, services.ErrCacheNotSynced) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -39,3 +49,44 @@ func (h *Handlers) HandlePods(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(models.PodList{Pods: pods})
 }
+
+func (h *Handlers) HandleWorkloads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	namespace := r.URL.Query().Get("namespace")
+	workloads, err := h.kubeService.ListWorkloads(r.Context(), namespace)
+	if errors.Is(err, services.ErrCacheNotSynced) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.WorkloadList{Workloads: workloads})
+}
+
+func (h *Handlers) HandleNodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	nodes, err := h.kubeService.ListNodes(r.Context())
+	if errors.Is(err, services.ErrCacheNotSynced) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.NodeList{Nodes: nodes})
+}
@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/stenstromen/tinykmetrics/internal/models"
+	"github.com/stenstromen/tinykmetrics/internal/services"
 )
 
 func (h *Handlers) HandleMetrics(w http.ResponseWriter, r *http.Request) {
@@ -14,7 +16,17 @@ func (h *Handlers) HandleMetrics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	metrics, err := h.influxService.QueryMetrics(r.Context(), query)
+	_, querier, _ := h.backends()
+	if querier == nil {
+		http.Error(w, "Metrics queries require the influxdb or memory sink", http.StatusNotImplemented)
+		return
+	}
+
+	metrics, err := querier.QueryMetrics(r.Context(), query)
+	if errors.Is(err, services.ErrInvalidQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -23,3 +35,93 @@ func (h *Handlers) HandleMetrics(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(metrics)
 }
+
+func (h *Handlers) HandleNodeMetrics(w http.ResponseWriter, r *http.Request) {
+	var query models.NodeMetricsQuery
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, querier, _ := h.backends()
+	if querier == nil {
+		http.Error(w, "Metrics queries require the influxdb or memory sink", http.StatusNotImplemented)
+		return
+	}
+
+	metrics, err := querier.QueryNodeMetrics(r.Context(), query)
+	if errors.Is(err, services.ErrInvalidQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metrics)
+}
+
+// HandleRestarts returns the container restarts within a time range, for
+// overlaying on the charts of /api/metrics.
+func (h *Handlers) HandleRestarts(w http.ResponseWriter, r *http.Request) {
+	var query models.RestartsQuery
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, querier, _ := h.backends()
+	if querier == nil {
+		http.Error(w, "Restart queries require the influxdb or memory sink", http.StatusNotImplemented)
+		return
+	}
+
+	restarts, err := querier.QueryRestarts(r.Context(), query)
+	if errors.Is(err, services.ErrInvalidQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if restarts == nil {
+		restarts = []models.RestartEvent{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(restarts)
+}
+
+// HandleEvents returns the Kubernetes Events recorded within a time range.
+func (h *Handlers) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	var query models.EventsQuery
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, querier, _ := h.backends()
+	if querier == nil {
+		http.Error(w, "Event queries require the influxdb or memory sink", http.StatusNotImplemented)
+		return
+	}
+
+	events, err := querier.QueryEvents(r.Context(), query)
+	if errors.Is(err, services.ErrInvalidQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if events == nil {
+		events = []models.Event{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
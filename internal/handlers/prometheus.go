@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/stenstromen/tinykmetrics/internal/services"
+)
+
+func (h *Handlers) HandlePrometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, _, exporter := h.backends()
+	if exporter == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", services.OpenMetricsContentType)
+	} else {
+		w.Header().Set("Content-Type", services.PrometheusContentType)
+	}
+
+	exporter.WriteMetrics(w, openMetrics)
+}
@@ -1,6 +1,10 @@
 package models
 
+// HealthStatus is the readiness report. InfluxDB and Writes are only set
+// when metrics are written to InfluxDB.
 type HealthStatus struct {
-	InfluxDB bool   `json:"influxdb"`
-	Status   string `json:"status"`
+	InfluxDB *bool       `json:"influxdb,omitempty"`
+	Cache    bool        `json:"cache"`
+	Status   string      `json:"status"`
+	Writes   *WriteStats `json:"writes,omitempty"`
 }
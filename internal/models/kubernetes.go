@@ -9,6 +9,23 @@ type PodList struct {
 	Pods []Pod `json:"pods"`
 }
 
+// Workload is a controller owning pods, such as a Deployment, with the
+// number of its pods in the cache.
+type Workload struct {
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Pods      int    `json:"pods"`
+}
+
+type WorkloadList struct {
+	Workloads []Workload `json:"workloads"`
+}
+
 type NamespaceList struct {
 	Namespaces []string `json:"namespaces"`
 }
+
+type NodeList struct {
+	Nodes []string `json:"nodes"`
+}
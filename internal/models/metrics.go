@@ -1,8 +1,93 @@
 package models
 
+import "time"
+
+// MetricsQuery selects pod metrics. Start and Stop accept a relative
+// duration such as "15m" or "2d", or an RFC3339 timestamp; an empty Stop
+// means now. Series are downsampled into Window sized steps using
+// Aggregate (mean, max, min, last or sum); an empty Window picks a step
+// from the length of the range.
+//
+// With WorkloadKind and WorkloadName, the workload in Namespace is
+// selected instead of a pod: each step of its containers is summed per
+// pod, and the pods are summed, or averaged per replica with Rollup
+// "mean".
 type MetricsQuery struct {
+	Start        string `json:"start"`
+	Stop         string `json:"stop"`
+	Namespace    string `json:"namespace"`
+	Pod          string `json:"pod"`
+	WorkloadKind string `json:"workload_kind"`
+	WorkloadName string `json:"workload_name"`
+	Rollup       string `json:"rollup"`
+	Window       string `json:"window"`
+	Aggregate    string `json:"aggregate"`
+}
+
+// NodeMetricsQuery selects node metrics, with the same time range and
+// aggregation options as MetricsQuery.
+type NodeMetricsQuery struct {
+	Start     string `json:"start"`
+	Stop      string `json:"stop"`
+	Node      string `json:"node"`
+	Window    string `json:"window"`
+	Aggregate string `json:"aggregate"`
+}
+
+// RestartsQuery selects container restarts, with the same time range and
+// namespace and pod filters as MetricsQuery.
+type RestartsQuery struct {
 	Start     string `json:"start"`
 	Stop      string `json:"stop"`
 	Namespace string `json:"namespace"`
 	Pod       string `json:"pod"`
 }
+
+// RestartEvent reports that a container restarted Restarts times since
+// the previous sample taken before Time. Reason and ExitCode are those of
+// the last termination.
+type RestartEvent struct {
+	Time         time.Time `json:"time"`
+	Namespace    string    `json:"namespace"`
+	Pod          string    `json:"pod"`
+	Container    string    `json:"container"`
+	Restarts     int64     `json:"restarts"`
+	RestartCount int64     `json:"restart_count"`
+	Reason       string    `json:"reason,omitempty"`
+	ExitCode     int64     `json:"exit_code"`
+}
+
+// EventsQuery selects Kubernetes Events, with the same time range and
+// namespace and pod filters as MetricsQuery.
+type EventsQuery struct {
+	Start     string `json:"start"`
+	Stop      string `json:"stop"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+}
+
+// Event is a Kubernetes Event about the object of Kind and Name.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Namespace string    `json:"namespace"`
+	Kind      string    `json:"kind"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	Count     int64     `json:"count"`
+	Source    string    `json:"source,omitempty"`
+}
+
+// WriteStats counts write batches and points since startup. DroppedPoints
+// are lost for good: failed and not spooled, or evicted from the spool.
+type WriteStats struct {
+	Batches        int64 `json:"batches"`
+	FailedBatches  int64 `json:"failed_batches"`
+	Points         int64 `json:"points"`
+	FailedPoints   int64 `json:"failed_points"`
+	Retries        int64 `json:"retries"`
+	SpooledPoints  int64 `json:"spooled_points"`
+	ReplayedPoints int64 `json:"replayed_points"`
+	DroppedPoints  int64 `json:"dropped_points"`
+}
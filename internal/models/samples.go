@@ -0,0 +1,141 @@
+package models
+
+import "time"
+
+// NodeSample holds the resource usage of a single node along with its
+// capacity and state from the Node object. Capacity, allocatable and
+// conditions are unset if the Node object is not known yet.
+type NodeSample struct {
+	Node              string
+	Role              string // comma-separated node-role.kubernetes.io roles
+	Zone              string
+	InstanceType      string
+	CPUUsage          int64 // millicores
+	MemoryUsage       int64 // bytes
+	CPUCapacity       int64 // millicores
+	CPUAllocatable    int64 // millicores
+	MemoryCapacity    int64 // bytes
+	MemoryAllocatable int64 // bytes
+	PodCapacity       int64
+	PodAllocatable    int64
+	RunningPods       int64
+	Conditions        map[string]bool // condition type, e.g. Ready, to whether it is True
+	Stats             *NodeStats      // nil unless read from the kubelet Summary API
+}
+
+// NodeStats are the node statistics from the kubelet Summary API. Unset
+// values are 0.
+type NodeStats struct {
+	Network          *NetworkStats
+	MemoryWorkingSet int64 // bytes
+	MemoryRSS        int64 // bytes
+	RootfsUsed       int64 // bytes
+	RootfsCapacity   int64 // bytes
+	ImagefsUsed      int64 // bytes
+	ImagefsCapacity  int64 // bytes
+}
+
+// NetworkStats are cumulative counters of the default network interface.
+type NetworkStats struct {
+	RxBytes  int64
+	RxErrors int64
+	TxBytes  int64
+	TxErrors int64
+}
+
+// ContainerSample holds the resource usage of a single container along
+// with its resource requests and limits. Unset requests and limits are 0.
+// The workload is the top-level controller owning the pod, e.g. a
+// Deployment, and is empty for bare pods.
+type ContainerSample struct {
+	Namespace     string
+	Pod           string
+	Container     string
+	WorkloadKind  string
+	WorkloadName  string
+	Tags          map[string]string // pod labels and annotations copied as tags
+	CPUUsage      int64             // millicores
+	MemoryUsage   int64             // bytes
+	CPURequest    int64             // millicores
+	CPULimit      int64             // millicores
+	MemoryRequest int64             // bytes
+	MemoryLimit   int64             // bytes
+	Stats         *ContainerStats   // nil unless read from the kubelet Summary API
+}
+
+// ContainerStats are the container statistics from the kubelet Summary
+// API. Unset values are 0.
+type ContainerStats struct {
+	MemoryWorkingSet int64 // bytes
+	MemoryRSS        int64 // bytes
+	RootfsUsed       int64 // bytes
+	LogsUsed         int64 // bytes
+}
+
+// PodSample holds the pod statistics from the kubelet Summary API, tagged
+// like the pod's containers.
+type PodSample struct {
+	Namespace            string
+	Pod                  string
+	WorkloadKind         string
+	WorkloadName         string
+	Tags                 map[string]string
+	Network              *NetworkStats
+	EphemeralStorageUsed int64 // bytes
+}
+
+// VolumeSample holds the usage of a volume backed by a
+// PersistentVolumeClaim, from the kubelet Summary API.
+type VolumeSample struct {
+	Namespace string
+	Pod       string
+	Volume    string
+	PVC       string
+	Used      int64 // bytes
+	Capacity  int64 // bytes
+	Available int64 // bytes
+}
+
+// ContainerStatusSample holds the state of a container from its Pod
+// object, along with the phase, readiness and QoS class of the pod. The
+// last termination is unset until the container has restarted.
+type ContainerStatusSample struct {
+	Namespace             string
+	Pod                   string
+	Container             string
+	WorkloadKind          string
+	WorkloadName          string
+	Tags                  map[string]string
+	Phase                 string // Pending, Running, Succeeded, Failed or Unknown
+	QOSClass              string // Guaranteed, Burstable or BestEffort
+	PodReady              bool
+	Ready                 bool
+	RestartCount          int64
+	LastTerminationReason string // e.g. OOMKilled or Error
+	LastExitCode          int64
+}
+
+// EventSample is a Kubernetes Event about an object, taken at the time
+// the event last occurred.
+type EventSample struct {
+	Time      time.Time
+	Namespace string
+	Kind      string // kind of the involved object, e.g. Pod
+	Name      string // name of the involved object
+	Type      string // Normal or Warning
+	Reason    string
+	Message   string
+	Count     int64
+	Source    string // component that reported the event
+}
+
+// MetricsBatch is the set of samples gathered in one collection cycle.
+type MetricsBatch struct {
+	Time       time.Time
+	Nodes      []NodeSample
+	Containers []ContainerSample
+	Pods       []PodSample    // only with the kubelet Summary API
+	Volumes    []VolumeSample // only with the kubelet Summary API
+	Statuses   []ContainerStatusSample
+	Events     []EventSample // recorded since the previous batch
+}
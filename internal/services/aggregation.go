@@ -0,0 +1,87 @@
+package services
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxQueryPoints is the number of points per series the automatic step
+// aims for.
+const maxQueryPoints = 300
+
+// aggregateFuncs are the aggregate functions accepted in queries.
+var aggregateFuncs = map[string]bool{
+	"mean": true,
+	"max":  true,
+	"min":  true,
+	"last": true,
+	"sum":  true,
+}
+
+// autoSteps are the candidate window sizes for automatic steps.
+var autoSteps = []time.Duration{
+	10 * time.Second,
+	15 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	2 * time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+	15 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+	2 * time.Hour,
+	3 * time.Hour,
+	6 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+}
+
+// Aggregation downsamples each series into windows of Every, reduced with
+// the aggregate function Fn.
+type Aggregation struct {
+	Every time.Duration
+	Fn    string
+}
+
+// ParseAggregation validates window and fn. An empty window picks a step
+// from the length of timeRange and an empty fn means "mean".
+func ParseAggregation(window, fn string, timeRange TimeRange) (Aggregation, error) {
+	agg := Aggregation{Fn: fn}
+
+	if agg.Fn == "" {
+		agg.Fn = "mean"
+	}
+	if !aggregateFuncs[agg.Fn] {
+		return Aggregation{}, fmt.Errorf("%w: aggregate %q must be one of mean, max, min, last, sum", ErrInvalidQuery, fn)
+	}
+
+	if window == "" {
+		agg.Every = autoStep(timeRange)
+		return agg, nil
+	}
+
+	every, err := parseDuration(window)
+	if err != nil || window[0] == '-' {
+		return Aggregation{}, fmt.Errorf("%w: window %q must be a positive duration such as 1m", ErrInvalidQuery, window)
+	}
+	if timeRange.Stop.Sub(timeRange.Start)/every > 10*maxQueryPoints {
+		return Aggregation{}, fmt.Errorf("%w: window %q is too small for the time range", ErrInvalidQuery, window)
+	}
+	agg.Every = every
+
+	return agg, nil
+}
+
+// autoStep returns the smallest step that keeps each series within
+// maxQueryPoints points.
+func autoStep(timeRange TimeRange) time.Duration {
+	target := timeRange.Stop.Sub(timeRange.Start) / maxQueryPoints
+	for _, step := range autoSteps {
+		if step >= target {
+			return step
+		}
+	}
+	return autoSteps[len(autoSteps)-1]
+}
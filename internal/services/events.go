@@ -0,0 +1,168 @@
+package services
+
+import (
+	"hash/fnv"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/stenstromen/tinykmetrics/internal/models"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+// maxPendingEvents bounds the events kept until the next collection; the
+// oldest are dropped beyond it.
+const maxPendingEvents = 10000
+
+// maxQueryEvents is the number of most recent events returned by a query.
+const maxQueryEvents = 1000
+
+// EventFilter selects the Events that are recorded. An empty list of
+// types or reasons matches all of them.
+type EventFilter struct {
+	types   []string
+	reasons []string
+}
+
+func NewEventFilter(types, reasons []string) *EventFilter {
+	return &EventFilter{types: types, reasons: reasons}
+}
+
+// Match reports whether events of eventType and reason are recorded.
+func (f *EventFilter) Match(eventType, reason string) bool {
+	return (len(f.types) == 0 || slices.Contains(f.types, eventType)) &&
+		(len(f.reasons) == 0 || slices.Contains(f.reasons, reason))
+}
+
+// SetEventFilter starts recording the Events matching filter, written
+// with the next collection; nil stops recording. The Event informer is
+// started on first use and keeps running.
+func (s *KubernetesService) SetEventFilter(filter *EventFilter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = filter
+	if filter == nil || s.informerFactory == nil || s.eventsWatched {
+		return
+	}
+
+	s.eventsWatched = true
+	informer := s.informerFactory.Core().V1().Events().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if event, ok := obj.(*corev1.Event); ok {
+				s.recordEvent(event)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// Resyncs replay unchanged events
+			old, ok1 := oldObj.(*corev1.Event)
+			event, ok2 := newObj.(*corev1.Event)
+			if ok1 && ok2 && old.ResourceVersion != event.ResourceVersion {
+				s.recordEvent(event)
+			}
+		},
+	})
+	// Informers added after the factory has started need starting
+	if s.informerStop != nil {
+		s.informerFactory.Start(s.informerStop)
+	}
+}
+
+func (s *KubernetesService) eventFilter() *EventFilter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.events
+}
+
+// recordEvent keeps event for the next collection if it matches the
+// event filter and, when it is about a namespaced object, the namespace
+// filter.
+func (s *KubernetesService) recordEvent(event *corev1.Event) {
+	filter := s.eventFilter()
+	if filter == nil || !filter.Match(event.Type, event.Reason) {
+		return
+	}
+	if namespace := event.InvolvedObject.Namespace; namespace != "" && !s.collectionFilter().MatchNamespace(namespace) {
+		return
+	}
+
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+
+	if len(s.pendingEvents) >= maxPendingEvents {
+		s.pendingEvents = s.pendingEvents[1:]
+		s.droppedEvents++
+	}
+	s.pendingEvents = append(s.pendingEvents, eventSample(event))
+}
+
+// takeEvents returns the events recorded since the previous call.
+func (s *KubernetesService) takeEvents() []models.EventSample {
+	s.eventsMu.Lock()
+	events, dropped := s.pendingEvents, s.droppedEvents
+	s.pendingEvents, s.droppedEvents = nil, 0
+	s.eventsMu.Unlock()
+
+	if dropped > 0 {
+		log.Printf("Dropped %d events recorded since the previous collection", dropped)
+	}
+	return events
+}
+
+// eventSample converts event, taking the time it last occurred from
+// whichever of the old and new Event API fields is set, plus the offset
+// of its UID.
+func eventSample(event *corev1.Event) models.EventSample {
+	t := event.LastTimestamp.Time
+	if event.Series != nil && !event.Series.LastObservedTime.IsZero() {
+		t = event.Series.LastObservedTime.Time
+	}
+	if t.IsZero() {
+		t = event.EventTime.Time
+	}
+	if t.IsZero() {
+		t = event.FirstTimestamp.Time
+	}
+	if t.IsZero() {
+		t = event.CreationTimestamp.Time
+	}
+
+	count := int64(event.Count)
+	if event.Series != nil {
+		count = int64(event.Series.Count)
+	}
+	if count == 0 {
+		count = 1
+	}
+
+	source := event.Source.Component
+	if source == "" {
+		source = event.ReportingController
+	}
+
+	return models.EventSample{
+		Time:      t.Add(eventOffset(event.UID)),
+		Namespace: event.InvolvedObject.Namespace,
+		Kind:      event.InvolvedObject.Kind,
+		Name:      event.InvolvedObject.Name,
+		Type:      event.Type,
+		Reason:    event.Reason,
+		Message:   event.Message,
+		Count:     count,
+		Source:    source,
+	}
+}
+
+// eventOffset returns a sub-millisecond offset derived from uid. Event
+// times have a resolution of a second, so distinct events about the same
+// object with the same reason would otherwise share a timestamp and
+// overwrite each other in InfluxDB. The same event always gets the same
+// offset, so writing it again, as on startup, still overwrites it.
+func eventOffset(uid types.UID) time.Duration {
+	h := fnv.New32a()
+	h.Write([]byte(uid))
+	return time.Duration(h.Sum32() % uint32(time.Millisecond))
+}
@@ -0,0 +1,301 @@
+package services
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/stenstromen/tinykmetrics/internal/models"
+)
+
+const (
+	FileFormatJSON         = "ndjson"
+	FileFormatLineProtocol = "line-protocol"
+)
+
+// filePrefix is the name shared by the active and the rotated files.
+const filePrefix = "tinykmetrics"
+
+// FileOptions configures the file sink. A zero MaxBytes or MaxAge disables
+// that rotation trigger and a zero MaxFiles keeps every rotated file.
+type FileOptions struct {
+	Dir      string
+	Format   string
+	MaxBytes int64
+	MaxAge   time.Duration
+	MaxFiles int
+	Compress bool
+}
+
+// fileRecord is a point in the NDJSON format.
+type fileRecord struct {
+	Measurement string                 `json:"measurement"`
+	Time        time.Time              `json:"time"`
+	Tags        map[string]string      `json:"tags"`
+	Fields      map[string]interface{} `json:"fields"`
+}
+
+// FileSink appends the node_metrics and pod_metrics points of every
+// collection to a local file, as NDJSON or InfluxDB line protocol. The
+// file is rotated once it reaches MaxBytes or MaxAge; rotated files are
+// named after the rotation time and optionally gzipped, so they can be
+// shipped elsewhere and imported later.
+type FileSink struct {
+	options FileOptions
+	ext     string
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	compressing sync.WaitGroup
+}
+
+func NewFileSink(options FileOptions) (*FileSink, error) {
+	s := &FileSink{options: options}
+	switch options.Format {
+	case FileFormatJSON:
+		s.ext = ".ndjson"
+	case FileFormatLineProtocol:
+		s.ext = ".lp"
+	default:
+		return nil, fmt.Errorf("unknown file format %q", options.Format)
+	}
+
+	if err := os.MkdirAll(options.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating file sink directory: %v", err)
+	}
+
+	// Rotated files left uncompressed by an earlier shutdown
+	if options.Compress {
+		for _, name := range s.rotated() {
+			if !strings.HasSuffix(name, ".gz") {
+				s.compress(filepath.Join(options.Dir, name))
+			}
+		}
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) Write(ctx context.Context, batch *models.MetricsBatch) error {
+	data, err := s.encode(batch)
+	if err != nil {
+		return fmt.Errorf("error encoding points: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return fmt.Errorf("file sink is closed")
+	}
+
+	if s.size > 0 && s.shouldRotate(int64(len(data))) {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("error rotating file: %v", err)
+		}
+	}
+
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("error writing file: %v", err)
+	}
+	return nil
+}
+
+// Close closes the active file and waits for pending compressions. The
+// active file is kept and appended to after a restart.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	var err error
+	if s.file != nil {
+		err = s.file.Close()
+		s.file = nil
+	}
+	s.mu.Unlock()
+
+	s.compressing.Wait()
+	return err
+}
+
+func (s *FileSink) encode(batch *models.MetricsBatch) ([]byte, error) {
+	var b strings.Builder
+	for _, p := range batchPoints(batch) {
+		if s.options.Format == FileFormatLineProtocol {
+			b.WriteString(write.PointToLineProtocol(p, time.Nanosecond))
+			continue
+		}
+
+		record := fileRecord{
+			Measurement: p.Name(),
+			Time:        p.Time().UTC(),
+			Tags:        make(map[string]string),
+			Fields:      make(map[string]interface{}),
+		}
+		for _, tag := range p.TagList() {
+			record.Tags[tag.Key] = tag.Value
+		}
+		for _, field := range p.FieldList() {
+			record.Fields[field.Key] = field.Value
+		}
+
+		line, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	return []byte(b.String()), nil
+}
+
+func (s *FileSink) shouldRotate(next int64) bool {
+	if s.options.MaxBytes > 0 && s.size+next > s.options.MaxBytes {
+		return true
+	}
+	return s.options.MaxAge > 0 && time.Since(s.opened) >= s.options.MaxAge
+}
+
+// open opens the active file for appending.
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(filepath.Join(s.options.Dir, filePrefix+s.ext), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening file: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("error opening file: %v", err)
+	}
+
+	s.file = f
+	s.size = info.Size()
+	s.opened = time.Now()
+	return nil
+}
+
+// rotate renames the active file after the current time, opens a new one
+// and compresses and prunes rotated files in the background. If that
+// fails, the current file stays active and rotation is retried with the
+// next write.
+func (s *FileSink) rotate() error {
+	active := filepath.Join(s.options.Dir, filePrefix+s.ext)
+	rotated := filepath.Join(s.options.Dir, fmt.Sprintf("%s-%s%s", filePrefix, time.Now().UTC().Format("20060102T150405.000Z"), s.ext))
+	if err := os.Rename(active, rotated); err != nil {
+		return err
+	}
+
+	current := s.file
+	if err := s.open(); err != nil {
+		if renameErr := os.Rename(rotated, active); renameErr != nil {
+			log.Printf("Error restoring %s: %v", active, renameErr)
+		}
+		return err
+	}
+	if err := current.Close(); err != nil {
+		log.Printf("Error closing rotated file: %v", err)
+	}
+
+	if s.options.Compress {
+		s.compress(rotated)
+	} else {
+		s.prune()
+	}
+	return nil
+}
+
+// compress gzips path in the background, replacing it with path.gz.
+func (s *FileSink) compress(path string) {
+	s.compressing.Add(1)
+	go func() {
+		defer s.compressing.Done()
+		if err := gzipFile(path); err != nil {
+			log.Printf("Error compressing %s: %v", path, err)
+			return
+		}
+		s.prune()
+	}()
+}
+
+// rotated returns the names of the rotated files, oldest first.
+func (s *FileSink) rotated() []string {
+	entries, err := os.ReadDir(s.options.Dir)
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && strings.HasPrefix(name, filePrefix+"-") &&
+			(strings.HasSuffix(name, s.ext) || strings.HasSuffix(name, s.ext+".gz")) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// prune removes the oldest rotated files beyond MaxFiles.
+func (s *FileSink) prune() {
+	if s.options.MaxFiles <= 0 {
+		return
+	}
+
+	names := s.rotated()
+	for len(names) > s.options.MaxFiles {
+		if err := os.Remove(filepath.Join(s.options.Dir, names[0])); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error removing rotated file: %v", err)
+		}
+		names = names[1:]
+	}
+}
+
+// gzipFile compresses path into path.gz and removes path.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := path + ".gz.tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"fmt"
+	"path"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// CollectionFilter selects the namespaces and pods metrics are collected
+// for. Namespaces match the include patterns, or any namespace if there
+// are none, and none of the exclude patterns. Patterns use path.Match
+// syntax, e.g. "kube-*". The zero value, and a nil filter, match
+// everything.
+type CollectionFilter struct {
+	include  []string
+	exclude  []string
+	selector labels.Selector
+}
+
+func NewCollectionFilter(include, exclude []string, podSelector string) (*CollectionFilter, error) {
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid namespace pattern %q: %v", pattern, err)
+		}
+	}
+
+	selector, err := labels.Parse(podSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pod selector %q: %v", podSelector, err)
+	}
+
+	return &CollectionFilter{include: include, exclude: exclude, selector: selector}, nil
+}
+
+// MatchNamespace reports whether pods in namespace are collected.
+func (f *CollectionFilter) MatchNamespace(namespace string) bool {
+	if f == nil {
+		return true
+	}
+	for _, pattern := range f.exclude {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, pattern := range f.include {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchPod reports whether a pod in namespace with podLabels is collected.
+func (f *CollectionFilter) MatchPod(namespace string, podLabels map[string]string) bool {
+	if !f.MatchNamespace(namespace) {
+		return false
+	}
+	return f.Selector().Matches(labels.Set(podLabels))
+}
+
+// Selector returns the pod label selector.
+func (f *CollectionFilter) Selector() labels.Selector {
+	if f == nil || f.selector == nil {
+		return labels.Everything()
+	}
+	return f.selector
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// fluxEscaper escapes everything that could terminate a Flux string literal
+// or start an interpolation inside it.
+var fluxEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	`${`, `\${`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+)
+
+// fluxString quotes s as a Flux string literal.
+func fluxString(s string) string {
+	return `"` + fluxEscaper.Replace(s) + `"`
+}
+
+// fluxDuration formats d as a Flux duration literal in the largest unit
+// that divides it evenly.
+func fluxDuration(d time.Duration) string {
+	for _, unit := range []struct {
+		suffix string
+		size   time.Duration
+	}{
+		{"w", 7 * 24 * time.Hour},
+		{"d", 24 * time.Hour},
+		{"h", time.Hour},
+		{"m", time.Minute},
+		{"s", time.Second},
+		{"ms", time.Millisecond},
+	} {
+		if d%unit.size == 0 {
+			return fmt.Sprintf("%d%s", d/unit.size, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dns", d)
+}
+
+// fluxAggregate downsamples every series with aggregateWindow.
+func fluxAggregate(agg Aggregation) string {
+	return fmt.Sprintf(` |> aggregateWindow(every: %s, fn: %s, createEmpty: false)`, fluxDuration(agg.Every), agg.Fn)
+}
+
+// fluxTime formats t as a Flux time literal.
+func fluxTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// validateNamespace rejects anything that is not a valid namespace name.
+func validateNamespace(namespace string) error {
+	if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		return fmt.Errorf("%w: namespace %q: %s", ErrInvalidQuery, namespace, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// validatePodName rejects anything that is not a valid pod name.
+func validatePodName(pod string) error {
+	if errs := validation.IsDNS1123Subdomain(pod); len(errs) > 0 {
+		return fmt.Errorf("%w: pod %q: %s", ErrInvalidQuery, pod, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// validateNodeName rejects anything that is not a valid node name.
+func validateNodeName(node string) error {
+	if errs := validation.IsDNS1123Subdomain(node); len(errs) > 0 {
+		return fmt.Errorf("%w: node %q: %s", ErrInvalidQuery, node, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// buildPodMetricsQuery returns the Flux query for pod metrics, downsampled
+// with agg. Every caller-supplied value is validated and then quoted, so
+// input can never change the structure of the query.
+func buildPodMetricsQuery(bucket string, timeRange TimeRange, agg Aggregation, namespace, pod string) (string, error) {
+	if namespace != "" {
+		if err := validateNamespace(namespace); err != nil {
+			return "", err
+		}
+	}
+	if pod != "" {
+		if err := validatePodName(pod); err != nil {
+			return "", err
+		}
+	}
+
+	query := fluxMeasurement(bucket, timeRange, "pod_metrics")
+
+	if namespace != "" {
+		query += fmt.Sprintf(` |> filter(fn: (r) => r.namespace == %s)`, fluxString(namespace))
+	}
+	if pod != "" {
+		query += fmt.Sprintf(` |> filter(fn: (r) => r.pod == %s)`, fluxString(pod))
+	}
+	query += fluxAggregate(agg)
+
+	return query, nil
+}
+
+// buildWorkloadMetricsQuery returns the Flux query for the metrics of a
+// workload, including pods that no longer exist: each container series is
+// downsampled with agg, the containers of a pod are summed and the pods
+// rolled up with fn, sum or mean.
+func buildWorkloadMetricsQuery(bucket string, timeRange TimeRange, agg Aggregation, namespace, kind, name, fn string) (string, error) {
+	if err := validateWorkload(namespace, kind, name); err != nil {
+		return "", err
+	}
+
+	fields := make([]string, len(workloadFields))
+	for i, field := range workloadFields {
+		fields[i] = "r._field == " + fluxString(field)
+	}
+
+	query := fluxMeasurement(bucket, timeRange, "pod_metrics")
+	query += fmt.Sprintf(` |> filter(fn: (r) => r.namespace == %s and r.workload_kind == %s and r.workload_name == %s)`,
+		fluxString(namespace), fluxString(kind), fluxString(name))
+	query += ` |> filter(fn: (r) => ` + strings.Join(fields, " or ") + `)`
+	query += fluxAggregate(agg)
+	query += fmt.Sprintf(`
+		|> group(columns: ["_time", "_field", "namespace", "workload_kind", "workload_name", "pod"])
+		|> sum()
+		|> group(columns: ["_time", "_field", "namespace", "workload_kind", "workload_name"])
+		|> %s()
+		|> group(columns: ["_field", "namespace", "workload_kind", "workload_name"])
+		|> sort(columns: ["_time"])`, fn)
+
+	return query, nil
+}
+
+// buildNodeMetricsQuery returns the Flux query for node metrics, downsampled
+// with agg and optionally restricted to a single node.
+func buildNodeMetricsQuery(bucket string, timeRange TimeRange, agg Aggregation, node string) (string, error) {
+	if node != "" {
+		if err := validateNodeName(node); err != nil {
+			return "", err
+		}
+	}
+
+	query := fluxMeasurement(bucket, timeRange, "node_metrics")
+
+	if node != "" {
+		query += fmt.Sprintf(` |> filter(fn: (r) => r.node == %s)`, fluxString(node))
+	}
+	query += fluxAggregate(agg)
+
+	return query, nil
+}
+
+// buildRestartsQuery returns the Flux query for container restarts: the
+// pod_status points whose restart count grew since the previous point of
+// the same container, with the increase in a restarts column.
+func buildRestartsQuery(bucket string, timeRange TimeRange, namespace, pod string) (string, error) {
+	if namespace != "" {
+		if err := validateNamespace(namespace); err != nil {
+			return "", err
+		}
+	}
+	if pod != "" {
+		if err := validatePodName(pod); err != nil {
+			return "", err
+		}
+	}
+
+	query := fluxMeasurement(bucket, timeRange, "pod_status")
+
+	if namespace != "" {
+		query += fmt.Sprintf(` |> filter(fn: (r) => r.namespace == %s)`, fluxString(namespace))
+	}
+	if pod != "" {
+		query += fmt.Sprintf(` |> filter(fn: (r) => r.pod == %s)`, fluxString(pod))
+	}
+	query += `
+		|> filter(fn: (r) => r._field == "restart_count" or r._field == "last_termination_reason" or r._field == "last_exit_code")
+		|> pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")
+		|> duplicate(column: "restart_count", as: "restarts")
+		|> difference(columns: ["restarts"], nonNegative: true)
+		|> filter(fn: (r) => r.restarts > 0)`
+
+	return query, nil
+}
+
+// buildEventsQuery returns the Flux query for the most recent
+// maxQueryEvents events, oldest first. The pod filter selects events
+// about that pod.
+func buildEventsQuery(bucket string, timeRange TimeRange, namespace, pod string) (string, error) {
+	if namespace != "" {
+		if err := validateNamespace(namespace); err != nil {
+			return "", err
+		}
+	}
+	if pod != "" {
+		if err := validatePodName(pod); err != nil {
+			return "", err
+		}
+	}
+
+	query := fluxMeasurement(bucket, timeRange, "events")
+
+	if namespace != "" {
+		query += fmt.Sprintf(` |> filter(fn: (r) => r.namespace == %s)`, fluxString(namespace))
+	}
+	if pod != "" {
+		query += fmt.Sprintf(` |> filter(fn: (r) => r.kind == "Pod" and r.name == %s)`, fluxString(pod))
+	}
+	query += fmt.Sprintf(`
+		|> pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")
+		|> group()
+		|> sort(columns: ["_time"])
+		|> tail(n: %d)`, maxQueryEvents)
+
+	return query, nil
+}
+
+// fluxMeasurement selects a measurement from bucket within timeRange.
+func fluxMeasurement(bucket string, timeRange TimeRange, measurement string) string {
+	return fmt.Sprintf(`
+		from(bucket: %s)
+		|> range(start: %s, stop: %s)
+		|> filter(fn: (r) => r._measurement == %s)`,
+		fluxString(bucket), fluxTime(timeRange.Start), fluxTime(timeRange.Stop), fluxString(measurement))
+}
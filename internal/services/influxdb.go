@@ -2,31 +2,91 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
+	"sync"
 	"time"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	http2 "github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
 	"github.com/stenstromen/tinykmetrics/internal/models"
 )
 
+// WriteOptions controls how collected points are batched and retried.
+type WriteOptions struct {
+	BatchSize     int           // points per write request
+	Concurrency   int           // batches flushed in parallel
+	MaxRetries    int           // retries per batch after the first attempt
+	RetryInterval time.Duration // initial backoff, doubled on each retry
+}
+
 type InfluxDBService struct {
-	Client influxdb2.Client
-	Org    string
-	Bucket string
+	Client  influxdb2.Client // nil in 1.x mode
+	Org     string
+	Bucket  string
+	v1      *influxV1Client // set in 1.x mode
+	Spool   *Spool          // optional buffer for points that could not be written
+	options WriteOptions
+
+	mu    sync.Mutex
+	stats models.WriteStats
+}
+
+func NewInfluxDBService(url, token, org, bucket string, options WriteOptions) *InfluxDBService {
+	return &InfluxDBService{
+		Client:  influxdb2.NewClient(url, token),
+		Org:     org,
+		Bucket:  bucket,
+		options: defaultWriteOptions(options),
+	}
 }
 
-func NewInfluxDBService(url, token, org, bucket string) *InfluxDBService {
+// NewInfluxDBV1Service creates a service for InfluxDB 1.x, or anything
+// accepting its /write endpoint such as Telegraf's influxdb_listener.
+// Queries use InfluxQL. An empty retentionPolicy uses the database
+// default and an empty username disables authentication.
+func NewInfluxDBV1Service(url, database, retentionPolicy, username, password string, options WriteOptions) *InfluxDBService {
 	return &InfluxDBService{
-		Client: influxdb2.NewClient(url, token),
-		Org:    org,
-		Bucket: bucket,
+		Bucket:  database,
+		v1:      newInfluxV1Client(url, database, retentionPolicy, username, password),
+		options: defaultWriteOptions(options),
+	}
+}
+
+func defaultWriteOptions(options WriteOptions) WriteOptions {
+	if options.BatchSize <= 0 {
+		options.BatchSize = 5000
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = 1
+	}
+	if options.RetryInterval <= 0 {
+		options.RetryInterval = time.Second
+	}
+	return options
+}
+
+// Close releases the InfluxDB client.
+func (s *InfluxDBService) Close() error {
+	if s.v1 != nil {
+		s.v1.client.CloseIdleConnections()
+		return nil
 	}
+	s.Client.Close()
+	return nil
 }
 
 func (s *InfluxDBService) CheckHealth() bool {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	if s.v1 != nil {
+		return s.v1.ping(ctx) == nil
+	}
+
 	ok, err := s.Client.Health(ctx)
 	if err != nil {
 		return false
@@ -34,22 +94,492 @@ func (s *InfluxDBService) CheckHealth() bool {
 	return ok.Status == "pass"
 }
 
+// Stats returns the write counters accumulated since startup.
+func (s *InfluxDBService) Stats() models.WriteStats {
+	s.mu.Lock()
+	stats := s.stats
+	s.mu.Unlock()
+
+	if s.Spool != nil {
+		stats.DroppedPoints += s.Spool.Dropped()
+	}
+	return stats
+}
+
+// Write stores a batch of samples as node_metrics and pod_metrics points.
+// Points are split into batches of options.BatchSize which are flushed
+// concurrently, retrying transient failures with exponential backoff.
+// Batches that still fail are kept in the spool, if configured, and
+// replayed in order once InfluxDB is healthy again.
+func (s *InfluxDBService) Write(ctx context.Context, batch *models.MetricsBatch) error {
+	var lines []string
+	for _, p := range batchPoints(batch) {
+		lines = append(lines, strings.TrimSuffix(write.PointToLineProtocol(p, time.Nanosecond), "\n"))
+	}
+
+	var chunks [][]string
+	for len(lines) > 0 {
+		n := min(s.options.BatchSize, len(lines))
+		chunks = append(chunks, lines[:n])
+		lines = lines[n:]
+	}
+
+	// New points must not overtake spooled ones, so they join the spool
+	// until the backlog has been replayed.
+	if s.Spool != nil && !s.replaySpool(ctx) {
+		spooled := s.spoolChunks(chunks)
+		log.Printf("InfluxDB unavailable, spooled %d points", spooled)
+		return nil
+	}
+
+	errs := make([]error, len(chunks))
+	sem := make(chan struct{}, s.options.Concurrency)
+
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, chunk []string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = s.writeLines(ctx, chunk)
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	var failed, failedPoints, points, spooled, dropped int
+	var failedErrs []error
+	for i, err := range errs {
+		points += len(chunks[i])
+		if err == nil {
+			continue
+		}
+		failed++
+		failedPoints += len(chunks[i])
+		// Batches aborted by shutdown are spooled for the next start
+		if s.Spool != nil && (isRetryable(err) || ctx.Err() != nil) {
+			spooled += s.spoolChunks(chunks[i : i+1])
+		} else {
+			dropped += len(chunks[i])
+			failedErrs = append(failedErrs, err)
+		}
+	}
+
+	s.mu.Lock()
+	s.stats.Batches += int64(len(chunks))
+	s.stats.FailedBatches += int64(failed)
+	s.stats.Points += int64(points)
+	s.stats.FailedPoints += int64(failedPoints)
+	s.stats.DroppedPoints += int64(dropped)
+	s.mu.Unlock()
+
+	log.Printf("Wrote %d points to InfluxDB in %d batches (%d batches, %d points failed, %d points spooled)",
+		points-failedPoints, len(chunks), failed, failedPoints, spooled)
+
+	if len(failedErrs) > 0 {
+		return fmt.Errorf("%d of %d InfluxDB batches failed: %w", failed, len(chunks), errors.Join(failedErrs...))
+	}
+	return nil
+}
+
+// replaySpool writes spooled points back once InfluxDB reports healthy and
+// reports whether the spool has been drained.
+func (s *InfluxDBService) replaySpool(ctx context.Context) bool {
+	if !s.Spool.Pending() {
+		return true
+	}
+	if !s.CheckHealth() {
+		return false
+	}
+
+	for {
+		replayed, err := s.Spool.Replay(func(lines []string) error {
+			return s.writeLines(ctx, lines)
+		})
+
+		s.mu.Lock()
+		s.stats.ReplayedPoints += int64(replayed)
+		s.mu.Unlock()
+
+		if replayed > 0 {
+			log.Printf("Replayed %d spooled points to InfluxDB", replayed)
+		}
+		if err == nil {
+			return true
+		}
+		if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return false
+		}
+		if !isRejected(err) {
+			log.Printf("Error replaying spooled points: %v", err)
+			return false
+		}
+
+		log.Printf("Discarding spooled points rejected by InfluxDB: %v", err)
+		if err := s.Spool.Discard(); err != nil {
+			log.Printf("Error discarding spooled points: %v", err)
+			return false
+		}
+	}
+}
+
+// spoolChunks appends chunks to the spool and returns the number of points
+// stored.
+func (s *InfluxDBService) spoolChunks(chunks [][]string) int {
+	spooled, dropped := 0, 0
+	for _, chunk := range chunks {
+		if err := s.Spool.Append(chunk); err != nil {
+			log.Printf("Error spooling %d points: %v", len(chunk), err)
+			dropped += len(chunk)
+			continue
+		}
+		spooled += len(chunk)
+	}
+
+	s.mu.Lock()
+	s.stats.SpooledPoints += int64(spooled)
+	s.stats.DroppedPoints += int64(dropped)
+	s.mu.Unlock()
+
+	return spooled
+}
+
+// writeLines writes a single batch of line protocol records, retrying
+// transient failures up to options.MaxRetries times.
+func (s *InfluxDBService) writeLines(ctx context.Context, lines []string) error {
+	writeRecord := func(ctx context.Context, lines ...string) error {
+		return s.v1.write(ctx, lines)
+	}
+	if s.v1 == nil {
+		writeRecord = s.Client.WriteAPIBlocking(s.Org, s.Bucket).WriteRecord
+	}
+
+	attempts := 0
+	return retry(ctx, fmt.Sprintf("batch of %d points", len(lines)), s.options.MaxRetries, s.options.RetryInterval, func() (bool, error) {
+		if attempts++; attempts > 1 {
+			s.mu.Lock()
+			s.stats.Retries++
+			s.mu.Unlock()
+		}
+		err := writeRecord(ctx, lines...)
+		return isRetryable(err), err
+	})
+}
+
+// isRetryable reports whether a write error is worth retrying: network
+// errors, rate limiting and server-side failures.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var httpErr *http2.Error
+	if errors.As(err, &httpErr) && httpErr.StatusCode != 0 {
+		return httpErr.StatusCode == 429 || httpErr.StatusCode >= 500
+	}
+	return true
+}
+
+// isRejected reports whether InfluxDB refused a write outright, so that
+// sending the same points again cannot succeed.
+func isRejected(err error) bool {
+	var httpErr *http2.Error
+	return errors.As(err, &httpErr) && httpErr.StatusCode >= 400 && httpErr.StatusCode < 500 && httpErr.StatusCode != 429
+}
+
+func batchPoints(batch *models.MetricsBatch) []*write.Point {
+	points := make([]*write.Point, 0, len(batch.Nodes)+len(batch.Containers)+len(batch.Pods)+len(batch.Volumes)+len(batch.Statuses)+len(batch.Events))
+
+	for _, node := range batch.Nodes {
+		points = append(points, influxdb2.NewPoint(
+			"node_metrics",
+			labelMap(nodeLabels(node)),
+			nodeFields(node),
+			batch.Time,
+		))
+	}
+
+	for _, container := range batch.Containers {
+		points = append(points, influxdb2.NewPoint(
+			"pod_metrics",
+			labelMap(containerLabels(container)),
+			containerFields(container),
+			batch.Time,
+		))
+	}
+
+	for _, pod := range batch.Pods {
+		points = append(points, influxdb2.NewPoint(
+			"pod_stats",
+			labelMap(podLabels(pod)),
+			podFields(pod),
+			batch.Time,
+		))
+	}
+
+	for _, volume := range batch.Volumes {
+		fields := map[string]interface{}{
+			"used":      volume.Used,
+			"capacity":  volume.Capacity,
+			"available": volume.Available,
+		}
+		if volume.Capacity > 0 {
+			fields["utilization"] = utilization(volume.Used, volume.Capacity)
+		}
+		points = append(points, influxdb2.NewPoint(
+			"volume_stats",
+			labelMap(volumeLabels(volume)),
+			fields,
+			batch.Time,
+		))
+	}
+
+	for _, status := range batch.Statuses {
+		points = append(points, influxdb2.NewPoint(
+			"pod_status",
+			labelMap(statusLabels(status)),
+			statusFields(status),
+			batch.Time,
+		))
+	}
+
+	// Events are written at the time they occurred
+	for _, event := range batch.Events {
+		fields := map[string]interface{}{
+			"message": lineBreaks.Replace(event.Message),
+			"count":   event.Count,
+		}
+		if event.Source != "" {
+			fields["source"] = event.Source
+		}
+		points = append(points, influxdb2.NewPoint(
+			"events",
+			labelMap(eventLabels(event)),
+			fields,
+			event.Time,
+		))
+	}
+
+	return points
+}
+
+// lineBreaks flattens multi-line strings. The client leaves line breaks in
+// string fields unescaped, which splits the record in spooled and file
+// line protocol.
+var lineBreaks = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ")
+
+// labelMap converts name/value pairs into a map.
+func labelMap(labels []string) map[string]string {
+	m := make(map[string]string, len(labels)/2)
+	for i := 0; i < len(labels); i += 2 {
+		m[labels[i]] = labels[i+1]
+	}
+	return m
+}
+
+// nodeConditions are the node conditions written as fields, 1 if the
+// condition is True and 0 otherwise.
+var nodeConditions = []struct {
+	condition string
+	field     string
+}{
+	{"Ready", "ready"},
+	{"MemoryPressure", "memory_pressure"},
+	{"DiskPressure", "disk_pressure"},
+	{"PIDPressure", "pid_pressure"},
+}
+
+// nodeFields returns the usage fields of a node, plus its capacity,
+// allocatable resources and conditions when known and the usage and
+// running pods as a percentage of each.
+func nodeFields(node models.NodeSample) map[string]interface{} {
+	fields := map[string]interface{}{
+		"cpu_usage":    node.CPUUsage,
+		"memory_usage": node.MemoryUsage,
+	}
+
+	for _, resource := range []struct {
+		name  string
+		usage int64
+		value int64
+	}{
+		{"cpu_capacity", node.CPUUsage, node.CPUCapacity},
+		{"cpu_allocatable", node.CPUUsage, node.CPUAllocatable},
+		{"memory_capacity", node.MemoryUsage, node.MemoryCapacity},
+		{"memory_allocatable", node.MemoryUsage, node.MemoryAllocatable},
+		{"pod_capacity", node.RunningPods, node.PodCapacity},
+		{"pod_allocatable", node.RunningPods, node.PodAllocatable},
+	} {
+		if resource.value <= 0 {
+			continue
+		}
+		fields[resource.name] = resource.value
+		fields[resource.name+"_utilization"] = utilization(resource.usage, resource.value)
+	}
+	if node.PodCapacity > 0 {
+		fields["running_pods"] = node.RunningPods
+	}
+
+	if node.Conditions != nil {
+		for _, c := range nodeConditions {
+			fields[c.field] = boolField(node.Conditions[c.condition])
+		}
+	}
+
+	if stats := node.Stats; stats != nil {
+		networkFields(fields, stats.Network)
+		setPositive(fields, map[string]int64{
+			"memory_working_set": stats.MemoryWorkingSet,
+			"memory_rss":         stats.MemoryRSS,
+			"rootfs_used":        stats.RootfsUsed,
+			"rootfs_capacity":    stats.RootfsCapacity,
+			"imagefs_used":       stats.ImagefsUsed,
+			"imagefs_capacity":   stats.ImagefsCapacity,
+		})
+		if stats.RootfsCapacity > 0 {
+			fields["rootfs_utilization"] = utilization(stats.RootfsUsed, stats.RootfsCapacity)
+		}
+		if stats.ImagefsCapacity > 0 {
+			fields["imagefs_utilization"] = utilization(stats.ImagefsUsed, stats.ImagefsCapacity)
+		}
+	}
+
+	return fields
+}
+
+// podFields returns the network and ephemeral storage fields of a pod.
+func podFields(pod models.PodSample) map[string]interface{} {
+	fields := map[string]interface{}{
+		"ephemeral_storage_used": pod.EphemeralStorageUsed,
+	}
+	networkFields(fields, pod.Network)
+	return fields
+}
+
+// statusFields returns the state of a container and its pod. Phase and
+// QoS class are strings, readiness is 1 or 0 and the last termination is
+// left out until the container has restarted.
+func statusFields(status models.ContainerStatusSample) map[string]interface{} {
+	fields := map[string]interface{}{
+		"phase":         status.Phase,
+		"pod_ready":     boolField(status.PodReady),
+		"ready":         boolField(status.Ready),
+		"restart_count": status.RestartCount,
+	}
+	if status.QOSClass != "" {
+		fields["qos_class"] = status.QOSClass
+	}
+	if status.LastTerminationReason != "" || status.LastExitCode != 0 {
+		fields["last_termination_reason"] = status.LastTerminationReason
+		fields["last_exit_code"] = status.LastExitCode
+	}
+	return fields
+}
+
+// boolField writes a flag as 1 or 0, so it can be aggregated.
+func boolField(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// networkFields adds the network counters to fields when known.
+func networkFields(fields map[string]interface{}, network *models.NetworkStats) {
+	if network == nil {
+		return
+	}
+	fields["network_rx_bytes"] = network.RxBytes
+	fields["network_rx_errors"] = network.RxErrors
+	fields["network_tx_bytes"] = network.TxBytes
+	fields["network_tx_errors"] = network.TxErrors
+}
+
+// setPositive adds the values greater than zero to fields, leaving out
+// statistics the kubelet did not report.
+func setPositive(fields map[string]interface{}, values map[string]int64) {
+	for name, value := range values {
+		if value > 0 {
+			fields[name] = value
+		}
+	}
+}
+
+// containerFields returns the usage fields of a container, plus its
+// requests and limits and the usage as a percentage of each when set.
+func containerFields(container models.ContainerSample) map[string]interface{} {
+	fields := map[string]interface{}{
+		"cpu_usage":    container.CPUUsage,
+		"memory_usage": container.MemoryUsage,
+	}
+
+	for _, resource := range []struct {
+		name  string
+		usage int64
+		value int64
+	}{
+		{"cpu_request", container.CPUUsage, container.CPURequest},
+		{"cpu_limit", container.CPUUsage, container.CPULimit},
+		{"memory_request", container.MemoryUsage, container.MemoryRequest},
+		{"memory_limit", container.MemoryUsage, container.MemoryLimit},
+	} {
+		if resource.value <= 0 {
+			continue
+		}
+		fields[resource.name] = resource.value
+		fields[resource.name+"_utilization"] = utilization(resource.usage, resource.value)
+	}
+
+	if stats := container.Stats; stats != nil {
+		setPositive(fields, map[string]int64{
+			"memory_working_set": stats.MemoryWorkingSet,
+			"memory_rss":         stats.MemoryRSS,
+			"rootfs_used":        stats.RootfsUsed,
+			"logs_used":          stats.LogsUsed,
+		})
+	}
+
+	return fields
+}
+
+// utilization returns usage as a percentage of capacity.
+func utilization(usage, capacity int64) float64 {
+	return float64(usage) / float64(capacity) * 100
+}
+
 func (s *InfluxDBService) QueryMetrics(ctx context.Context, query models.MetricsQuery) (interface{}, error) {
-	queryAPI := s.Client.QueryAPI(s.Org)
+	timeRange, err := ParseTimeRange(query.Start, query.Stop, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	agg, err := ParseAggregation(query.Window, query.Aggregate, timeRange)
+	if err != nil {
+		return nil, err
+	}
 
-	fluxQuery := fmt.Sprintf(`
-		from(bucket: "%s")
-		|> range(start: -%s)
-		|> filter(fn: (r) => r._measurement == "pod_metrics")`,
-		s.Bucket, query.Start)
+	if isWorkloadQuery(query) {
+		return s.queryWorkloadMetrics(ctx, query, timeRange, agg)
+	}
 
-	if query.Namespace != "" {
-		fluxQuery += fmt.Sprintf(` |> filter(fn: (r) => r.namespace == "%s")`, query.Namespace)
+	if s.v1 != nil {
+		q, err := buildPodMetricsInfluxQL(s.v1.retentionPolicy, timeRange, agg, query.Namespace, query.Pod)
+		if err != nil {
+			return nil, err
+		}
+		series, err := s.v1.query(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		return influxQLPoints(series, agg, "namespace", "pod", "container"), nil
 	}
-	if query.Pod != "" {
-		fluxQuery += fmt.Sprintf(` |> filter(fn: (r) => r.pod == "%s")`, query.Pod)
+
+	fluxQuery, err := buildPodMetricsQuery(s.Bucket, timeRange, agg, query.Namespace, query.Pod)
+	if err != nil {
+		return nil, err
 	}
 
+	queryAPI := s.Client.QueryAPI(s.Org)
 	result, err := queryAPI.Query(ctx, fluxQuery)
 	if err != nil {
 		return nil, err
@@ -70,3 +600,194 @@ func (s *InfluxDBService) QueryMetrics(ctx context.Context, query models.Metrics
 
 	return metrics, result.Err()
 }
+
+// queryWorkloadMetrics answers a MetricsQuery selecting a workload.
+func (s *InfluxDBService) queryWorkloadMetrics(ctx context.Context, query models.MetricsQuery, timeRange TimeRange, agg Aggregation) (interface{}, error) {
+	fn, err := parseWorkloadQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.v1 != nil {
+		q, err := buildWorkloadMetricsInfluxQL(s.v1.retentionPolicy, timeRange, agg, query.Namespace, query.WorkloadKind, query.WorkloadName)
+		if err != nil {
+			return nil, err
+		}
+		series, err := s.v1.query(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		points := influxQLPoints(series, agg, "namespace", "pod", "container")
+		return rollupWorkload(points, fn, query.Namespace, query.WorkloadKind, query.WorkloadName), nil
+	}
+
+	fluxQuery, err := buildWorkloadMetricsQuery(s.Bucket, timeRange, agg, query.Namespace, query.WorkloadKind, query.WorkloadName, fn)
+	if err != nil {
+		return nil, err
+	}
+
+	queryAPI := s.Client.QueryAPI(s.Org)
+	result, err := queryAPI.Query(ctx, fluxQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	var metrics []map[string]interface{}
+	for result.Next() {
+		metrics = append(metrics, map[string]interface{}{
+			"time":          result.Record().Time(),
+			"value":         result.Record().Value(),
+			"field":         result.Record().Field(),
+			"namespace":     result.Record().ValueByKey("namespace"),
+			"workload_kind": result.Record().ValueByKey("workload_kind"),
+			"workload_name": result.Record().ValueByKey("workload_name"),
+		})
+	}
+
+	return metrics, result.Err()
+}
+
+func (s *InfluxDBService) QueryNodeMetrics(ctx context.Context, query models.NodeMetricsQuery) (interface{}, error) {
+	timeRange, err := ParseTimeRange(query.Start, query.Stop, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	agg, err := ParseAggregation(query.Window, query.Aggregate, timeRange)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.v1 != nil {
+		q, err := buildNodeMetricsInfluxQL(s.v1.retentionPolicy, timeRange, agg, query.Node)
+		if err != nil {
+			return nil, err
+		}
+		series, err := s.v1.query(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		return influxQLPoints(series, agg, "node"), nil
+	}
+
+	fluxQuery, err := buildNodeMetricsQuery(s.Bucket, timeRange, agg, query.Node)
+	if err != nil {
+		return nil, err
+	}
+
+	queryAPI := s.Client.QueryAPI(s.Org)
+	result, err := queryAPI.Query(ctx, fluxQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	var metrics []map[string]interface{}
+	for result.Next() {
+		metrics = append(metrics, map[string]interface{}{
+			"time":  result.Record().Time(),
+			"value": result.Record().Value(),
+			"field": result.Record().Field(),
+			"node":  result.Record().ValueByKey("node"),
+		})
+	}
+
+	return metrics, result.Err()
+}
+
+func (s *InfluxDBService) QueryRestarts(ctx context.Context, query models.RestartsQuery) ([]models.RestartEvent, error) {
+	timeRange, err := ParseTimeRange(query.Start, query.Stop, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if s.v1 != nil {
+		every := autoStep(timeRange)
+		q, err := buildRestartsInfluxQL(s.v1.retentionPolicy, timeRange, every, query.Namespace, query.Pod)
+		if err != nil {
+			return nil, err
+		}
+		series, err := s.v1.query(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		return influxQLRestarts(series, every), nil
+	}
+
+	fluxQuery, err := buildRestartsQuery(s.Bucket, timeRange, query.Namespace, query.Pod)
+	if err != nil {
+		return nil, err
+	}
+
+	queryAPI := s.Client.QueryAPI(s.Org)
+	result, err := queryAPI.Query(ctx, fluxQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	var events []models.RestartEvent
+	for result.Next() {
+		record := result.Record()
+		event := models.RestartEvent{Time: record.Time()}
+		event.Namespace, _ = record.ValueByKey("namespace").(string)
+		event.Pod, _ = record.ValueByKey("pod").(string)
+		event.Container, _ = record.ValueByKey("container").(string)
+		event.Restarts, _ = record.ValueByKey("restarts").(int64)
+		event.RestartCount, _ = record.ValueByKey("restart_count").(int64)
+		event.Reason, _ = record.ValueByKey("last_termination_reason").(string)
+		event.ExitCode, _ = record.ValueByKey("last_exit_code").(int64)
+		events = append(events, event)
+	}
+
+	return sortRestarts(events), result.Err()
+}
+
+func (s *InfluxDBService) QueryEvents(ctx context.Context, query models.EventsQuery) ([]models.Event, error) {
+	timeRange, err := ParseTimeRange(query.Start, query.Stop, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if s.v1 != nil {
+		q, err := buildEventsInfluxQL(s.v1.retentionPolicy, timeRange, query.Namespace, query.Pod)
+		if err != nil {
+			return nil, err
+		}
+		series, err := s.v1.query(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		return influxQLEvents(series), nil
+	}
+
+	fluxQuery, err := buildEventsQuery(s.Bucket, timeRange, query.Namespace, query.Pod)
+	if err != nil {
+		return nil, err
+	}
+
+	queryAPI := s.Client.QueryAPI(s.Org)
+	result, err := queryAPI.Query(ctx, fluxQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	var events []models.Event
+	for result.Next() {
+		record := result.Record()
+		event := models.Event{Time: record.Time()}
+		event.Namespace, _ = record.ValueByKey("namespace").(string)
+		event.Kind, _ = record.ValueByKey("kind").(string)
+		event.Name, _ = record.ValueByKey("name").(string)
+		event.Type, _ = record.ValueByKey("type").(string)
+		event.Reason, _ = record.ValueByKey("reason").(string)
+		event.Message, _ = record.ValueByKey("message").(string)
+		event.Count, _ = record.ValueByKey("count").(int64)
+		event.Source, _ = record.ValueByKey("source").(string)
+		events = append(events, event)
+	}
+
+	return events, result.Err()
+}
@@ -0,0 +1,316 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	http2 "github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/stenstromen/tinykmetrics/internal/models"
+)
+
+// influxV1Client talks to the InfluxDB 1.x HTTP API: line protocol on
+// /write, InfluxQL on /query and /ping for health. Telegraf's
+// influxdb_listener accepts the same writes.
+type influxV1Client struct {
+	url             string
+	database        string
+	retentionPolicy string
+	username        string
+	password        string
+	client          *http.Client
+}
+
+// influxQLSeries is a series from an InfluxQL query result.
+type influxQLSeries struct {
+	Name    string            `json:"name"`
+	Tags    map[string]string `json:"tags"`
+	Columns []string          `json:"columns"`
+	Values  [][]interface{}   `json:"values"`
+}
+
+type influxQLResponse struct {
+	Results []struct {
+		Series []influxQLSeries `json:"series"`
+		Error  string           `json:"error"`
+	} `json:"results"`
+	Error string `json:"error"`
+}
+
+func newInfluxV1Client(rawURL, database, retentionPolicy, username, password string) *influxV1Client {
+	return &influxV1Client{
+		url:             strings.TrimSuffix(rawURL, "/"),
+		database:        database,
+		retentionPolicy: retentionPolicy,
+		username:        username,
+		password:        password,
+		client:          &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// write posts lines to /write. Failures are returned as *http2.Error, the
+// same as the v2 client, so they are retried in the same way.
+func (c *influxV1Client) write(ctx context.Context, lines []string) error {
+	params := url.Values{"db": {c.database}, "precision": {"ns"}}
+	if c.retentionPolicy != "" {
+		params.Set("rp", c.retentionPolicy)
+	}
+
+	body := strings.NewReader(strings.Join(lines, "\n"))
+	resp, err := c.do(ctx, http.MethodPost, "/write", params, body)
+	if err != nil {
+		return http2.NewError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return responseError(resp)
+}
+
+// ping reports whether the server answers /ping.
+func (c *influxV1Client) ping(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodGet, "/ping", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// query runs an InfluxQL query, returning times as Unix nanoseconds.
+func (c *influxV1Client) query(ctx context.Context, q string) ([]influxQLSeries, error) {
+	params := url.Values{"db": {c.database}, "q": {q}, "epoch": {"ns"}}
+
+	resp, err := c.do(ctx, http.MethodGet, "/query", params, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return nil, responseError(resp)
+	}
+
+	var result influxQLResponse
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&result); err != nil {
+		return nil, fmt.Errorf("error decoding InfluxQL response: %v", err)
+	}
+	if result.Error != "" {
+		return nil, fmt.Errorf("error running InfluxQL query: %s", result.Error)
+	}
+
+	var series []influxQLSeries
+	for _, r := range result.Results {
+		if r.Error != "" {
+			return nil, fmt.Errorf("error running InfluxQL query: %s", r.Error)
+		}
+		series = append(series, r.Series...)
+	}
+	return series, nil
+}
+
+func (c *influxV1Client) do(ctx context.Context, method, path string, params url.Values, body io.Reader) (*http.Response, error) {
+	u := c.url + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "tinykmetrics")
+	if body != nil {
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	return c.client.Do(req)
+}
+
+// responseError turns an unsuccessful response into an *http2.Error,
+// keeping the message InfluxDB 1.x sends as {"error": "..."}.
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	httpErr := &http2.Error{StatusCode: resp.StatusCode, Code: resp.Status, Header: resp.Header}
+	var msg struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &msg) == nil && msg.Error != "" {
+		httpErr.Message = msg.Error
+	} else {
+		httpErr.Message = strings.TrimSpace(string(body))
+	}
+	if httpErr.Message == "" {
+		httpErr.Message = "no response body"
+	}
+	if retryAfter, err := strconv.ParseUint(resp.Header.Get("Retry-After"), 10, 32); err == nil {
+		httpErr.RetryAfter = uint(retryAfter)
+	}
+
+	return httpErr
+}
+
+// influxQLPoints flattens series returned by an aggregate query into
+// time/value/field points carrying the given tags, the same shape as the
+// Flux results. Aggregate columns are named "<fn>_<field>"; the window
+// start InfluxQL reports is moved to the window end to match
+// aggregateWindow.
+func influxQLPoints(series []influxQLSeries, agg Aggregation, tags ...string) []map[string]interface{} {
+	prefix := agg.Fn + "_"
+
+	var points []map[string]interface{}
+	for _, s := range series {
+		for _, row := range s.Values {
+			if len(row) != len(s.Columns) {
+				continue
+			}
+			ts, ok := row[0].(json.Number)
+			if !ok {
+				continue
+			}
+			ns, err := ts.Int64()
+			if err != nil {
+				continue
+			}
+			t := time.Unix(0, ns).UTC().Add(agg.Every)
+
+			for i, column := range s.Columns[1:] {
+				n, ok := row[i+1].(json.Number)
+				if !ok {
+					continue
+				}
+				value, err := n.Float64()
+				if err != nil {
+					continue
+				}
+
+				point := map[string]interface{}{
+					"time":  t,
+					"value": value,
+					"field": strings.TrimPrefix(column, prefix),
+				}
+				for _, tag := range tags {
+					point[tag] = s.Tags[tag]
+				}
+				points = append(points, point)
+			}
+		}
+	}
+	return points
+}
+
+// influxQLRestarts finds the restarts in the windows returned by
+// buildRestartsInfluxQL, reporting each at the end of its window.
+func influxQLRestarts(series []influxQLSeries, every time.Duration) []models.RestartEvent {
+	tracker := newRestartTracker()
+	for _, s := range series {
+		for _, row := range s.Values {
+			if len(row) != len(s.Columns) {
+				continue
+			}
+			ts, ok := row[0].(json.Number)
+			if !ok {
+				continue
+			}
+			ns, err := ts.Int64()
+			if err != nil {
+				continue
+			}
+
+			status := models.ContainerStatusSample{
+				Namespace: s.Tags["namespace"],
+				Pod:       s.Tags["pod"],
+				Container: s.Tags["container"],
+			}
+			for i, column := range s.Columns[1:] {
+				switch value := row[i+1].(type) {
+				case json.Number:
+					n, _ := value.Int64()
+					switch column {
+					case "restart_count":
+						status.RestartCount = n
+					case "last_exit_code":
+						status.LastExitCode = n
+					}
+				case string:
+					if column == "last_termination_reason" {
+						status.LastTerminationReason = value
+					}
+				}
+			}
+			tracker.add(time.Unix(0, ns).Add(every), status)
+		}
+	}
+	return tracker.result()
+}
+
+// influxQLEvents converts the rows returned by buildEventsInfluxQL into
+// events, oldest first.
+func influxQLEvents(series []influxQLSeries) []models.Event {
+	var events []models.Event
+	for _, s := range series {
+		for _, row := range s.Values {
+			if len(row) != len(s.Columns) {
+				continue
+			}
+			ts, ok := row[0].(json.Number)
+			if !ok {
+				continue
+			}
+			ns, err := ts.Int64()
+			if err != nil {
+				continue
+			}
+
+			event := models.Event{Time: time.Unix(0, ns).UTC()}
+			for i, column := range s.Columns[1:] {
+				if column == "count" {
+					if n, ok := row[i+1].(json.Number); ok {
+						event.Count, _ = n.Int64()
+					}
+					continue
+				}
+				value, _ := row[i+1].(string)
+				switch column {
+				case "message":
+					event.Message = value
+				case "source":
+					event.Source = value
+				case "namespace":
+					event.Namespace = value
+				case "kind":
+					event.Kind = value
+				case "name":
+					event.Name = value
+				case "type":
+					event.Type = value
+				case "reason":
+					event.Reason = value
+				}
+			}
+			events = append(events, event)
+		}
+	}
+	slices.Reverse(events)
+	return events
+}
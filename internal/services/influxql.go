@@ -0,0 +1,152 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// influxQLIdentEscaper escapes a double-quoted InfluxQL identifier.
+var influxQLIdentEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// influxQLStringEscaper escapes a single-quoted InfluxQL string literal.
+var influxQLStringEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`)
+
+func influxQLIdent(s string) string {
+	return `"` + influxQLIdentEscaper.Replace(s) + `"`
+}
+
+func influxQLString(s string) string {
+	return `'` + influxQLStringEscaper.Replace(s) + `'`
+}
+
+// buildPodMetricsInfluxQL is the InfluxQL counterpart of
+// buildPodMetricsQuery. Values are validated and quoted the same way.
+func buildPodMetricsInfluxQL(retentionPolicy string, timeRange TimeRange, agg Aggregation, namespace, pod string) (string, error) {
+	var conditions []string
+	if namespace != "" {
+		if err := validateNamespace(namespace); err != nil {
+			return "", err
+		}
+		conditions = append(conditions, `"namespace" = `+influxQLString(namespace))
+	}
+	if pod != "" {
+		if err := validatePodName(pod); err != nil {
+			return "", err
+		}
+		conditions = append(conditions, `"pod" = `+influxQLString(pod))
+	}
+
+	return influxQLSelect(retentionPolicy, "pod_metrics", timeRange, agg, conditions), nil
+}
+
+// buildWorkloadMetricsInfluxQL selects the metrics of every container of
+// a workload. Unlike buildWorkloadMetricsQuery it leaves the rollup to
+// rollupWorkload, as InfluxQL cannot aggregate the result of a GROUP BY
+// again.
+func buildWorkloadMetricsInfluxQL(retentionPolicy string, timeRange TimeRange, agg Aggregation, namespace, kind, name string) (string, error) {
+	if err := validateWorkload(namespace, kind, name); err != nil {
+		return "", err
+	}
+	conditions := []string{
+		`"namespace" = ` + influxQLString(namespace),
+		`"workload_kind" = ` + influxQLString(kind),
+		`"workload_name" = ` + influxQLString(name),
+	}
+
+	return influxQLSelect(retentionPolicy, "pod_metrics", timeRange, agg, conditions), nil
+}
+
+// buildNodeMetricsInfluxQL is the InfluxQL counterpart of
+// buildNodeMetricsQuery.
+func buildNodeMetricsInfluxQL(retentionPolicy string, timeRange TimeRange, agg Aggregation, node string) (string, error) {
+	var conditions []string
+	if node != "" {
+		if err := validateNodeName(node); err != nil {
+			return "", err
+		}
+		conditions = append(conditions, `"node" = `+influxQLString(node))
+	}
+
+	return influxQLSelect(retentionPolicy, "node_metrics", timeRange, agg, conditions), nil
+}
+
+// buildRestartsInfluxQL selects the last restart count and termination
+// of each container in windows of every. Unlike buildRestartsQuery it
+// leaves finding the windows where the count grew to the caller, as
+// InfluxQL cannot compute a difference alongside other fields.
+func buildRestartsInfluxQL(retentionPolicy string, timeRange TimeRange, every time.Duration, namespace, pod string) (string, error) {
+	conditions := []string{
+		"time >= " + influxQLString(fluxTime(timeRange.Start)),
+		"time < " + influxQLString(fluxTime(timeRange.Stop)),
+	}
+	if namespace != "" {
+		if err := validateNamespace(namespace); err != nil {
+			return "", err
+		}
+		conditions = append(conditions, `"namespace" = `+influxQLString(namespace))
+	}
+	if pod != "" {
+		if err := validatePodName(pod); err != nil {
+			return "", err
+		}
+		conditions = append(conditions, `"pod" = `+influxQLString(pod))
+	}
+
+	from := influxQLIdent("pod_status")
+	if retentionPolicy != "" {
+		from = influxQLIdent(retentionPolicy) + "." + from
+	}
+
+	return fmt.Sprintf(`SELECT last("restart_count") AS "restart_count", last("last_termination_reason") AS "last_termination_reason", last("last_exit_code") AS "last_exit_code" FROM %s WHERE %s GROUP BY time(%s), "namespace", "pod", "container" fill(none)`,
+		from, strings.Join(conditions, " AND "), fluxDuration(every)), nil
+}
+
+// buildEventsInfluxQL is the InfluxQL counterpart of buildEventsQuery,
+// returning the most recent events first. Tags are selected as columns
+// so all events come back as one series.
+func buildEventsInfluxQL(retentionPolicy string, timeRange TimeRange, namespace, pod string) (string, error) {
+	conditions := []string{
+		"time >= " + influxQLString(fluxTime(timeRange.Start)),
+		"time < " + influxQLString(fluxTime(timeRange.Stop)),
+	}
+	if namespace != "" {
+		if err := validateNamespace(namespace); err != nil {
+			return "", err
+		}
+		conditions = append(conditions, `"namespace" = `+influxQLString(namespace))
+	}
+	if pod != "" {
+		if err := validatePodName(pod); err != nil {
+			return "", err
+		}
+		conditions = append(conditions, `"kind" = 'Pod'`, `"name" = `+influxQLString(pod))
+	}
+
+	from := influxQLIdent("events")
+	if retentionPolicy != "" {
+		from = influxQLIdent(retentionPolicy) + "." + from
+	}
+
+	return fmt.Sprintf(`SELECT "message", "count", "source", "namespace", "kind", "name", "type", "reason" FROM %s WHERE %s ORDER BY time DESC LIMIT %d`,
+		from, strings.Join(conditions, " AND "), maxQueryEvents), nil
+}
+
+// influxQLSelect aggregates every field of measurement within timeRange,
+// grouped into windows of agg.Every and by all tags. An empty
+// retentionPolicy selects the database default. InfluxQL accepts the
+// same duration literals as Flux.
+func influxQLSelect(retentionPolicy, measurement string, timeRange TimeRange, agg Aggregation, conditions []string) string {
+	from := influxQLIdent(measurement)
+	if retentionPolicy != "" {
+		from = influxQLIdent(retentionPolicy) + "." + from
+	}
+
+	conditions = append([]string{
+		"time >= " + influxQLString(fluxTime(timeRange.Start)),
+		"time < " + influxQLString(fluxTime(timeRange.Stop)),
+	}, conditions...)
+
+	return fmt.Sprintf(`SELECT %s(*) FROM %s WHERE %s GROUP BY time(%s), * fill(none)`,
+		agg.Fn, from, strings.Join(conditions, " AND "), fluxDuration(agg.Every))
+}
@@ -2,23 +2,95 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
-	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/stenstromen/tinykmetrics/internal/models"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	metricsv "k8s.io/metrics/pkg/client/clientset/versioned"
 )
 
+// informerResync is how often the informers replay their cache.
+const informerResync = 10 * time.Minute
+
+// ErrCacheNotSynced is returned while the informer caches are still
+// loading.
+var ErrCacheNotSynced = errors.New("kubernetes cache not synced yet")
+
 type KubernetesService struct {
-	client        *kubernetes.Clientset
-	metricsClient *metricsv.Clientset
-	testMode      bool
-	firstRun      bool // Track if this is the first collection run
+	client          *kubernetes.Clientset
+	metricsClient   *metricsv.Clientset
+	informerFactory informers.SharedInformerFactory
+	podLister       corelisters.PodLister
+	namespaceLister corelisters.NamespaceLister
+	nodeLister      corelisters.NodeLister
+	rsLister        appslisters.ReplicaSetLister
+	jobLister       batchlisters.JobLister
+	cacheSynced     []cache.InformerSynced
+	testMode        bool
+	firstRun        bool // Track if this is the first collection run
+	mockCollections int  // collections of mock metrics, to make mock restarts
+
+	// collectCtx outlives the collection loop so an in-flight collection
+	// can finish after shutdown starts; abortCollect cancels it.
+	collectCtx   context.Context
+	abortCollect context.CancelFunc
+	stopped      chan struct{}
+	intervals    chan time.Duration
+
+	mu            sync.RWMutex
+	filter        *CollectionFilter
+	tagger        *PodTagger
+	summary       *SummaryOptions
+	events        *EventFilter
+	eventsWatched bool
+	informerStop  <-chan struct{} // set once the informers have started
+
+	eventsMu      sync.Mutex
+	pendingEvents []models.EventSample
+	droppedEvents int
+}
+
+// mockPodLabels are the labels of the pods in test mode.
+var mockPodLabels = map[string]map[string]string{
+	"web-app-1":    {"app": "web-app", "team": "frontend"},
+	"kube-dns-1":   {"k8s-app": "kube-dns"},
+	"prometheus-1": {"app": "prometheus", "team": "observability"},
+	"postgres-1":   {"app": "postgres", "team": "data"},
+}
+
+// mockPodWorkloads are the kind and name of the workloads owning the pods
+// in test mode.
+var mockPodWorkloads = map[string][2]string{
+	"web-app-1":    {"Deployment", "web-app"},
+	"kube-dns-1":   {"Deployment", "kube-dns"},
+	"prometheus-1": {"StatefulSet", "prometheus"},
+	"postgres-1":   {"StatefulSet", "postgres"},
+}
+
+// mockPodNodes are the nodes the pods run on in test mode.
+var mockPodNodes = map[string]string{
+	"web-app-1":    "node-1",
+	"kube-dns-1":   "node-1",
+	"prometheus-1": "node-2",
+	"postgres-1":   "node-3",
 }
 
 func NewKubernetesService(config *rest.Config, testMode bool) (*KubernetesService, error) {
@@ -32,45 +104,162 @@ func NewKubernetesService(config *rest.Config, testMode bool) (*KubernetesServic
 		return nil, fmt.Errorf("error creating metrics client: %v", err)
 	}
 
-	return &KubernetesService{
-		client:        client,
-		metricsClient: metricsClient,
-		testMode:      testMode,
-		firstRun:      true,
-	}, nil
+	factory := informers.NewSharedInformerFactoryWithOptions(client, informerResync,
+		informers.WithTransform(stripManagedFields))
+	pods := factory.Core().V1().Pods()
+	namespaces := factory.Core().V1().Namespaces()
+	nodes := factory.Core().V1().Nodes()
+	replicaSets := factory.Apps().V1().ReplicaSets()
+	jobs := factory.Batch().V1().Jobs()
+
+	s := &KubernetesService{
+		client:          client,
+		metricsClient:   metricsClient,
+		informerFactory: factory,
+		podLister:       pods.Lister(),
+		namespaceLister: namespaces.Lister(),
+		nodeLister:      nodes.Lister(),
+		rsLister:        replicaSets.Lister(),
+		jobLister:       jobs.Lister(),
+		cacheSynced: []cache.InformerSynced{
+			pods.Informer().HasSynced,
+			namespaces.Informer().HasSynced,
+			nodes.Informer().HasSynced,
+			replicaSets.Informer().HasSynced,
+			jobs.Informer().HasSynced,
+		},
+		testMode: testMode,
+		firstRun: true,
+	}
+	s.collectCtx, s.abortCollect = context.WithCancel(context.Background())
+	s.stopped = make(chan struct{})
+	s.intervals = make(chan time.Duration, 1)
+
+	return s, nil
 }
 
 // NewKubernetesServiceWithFakeClient creates a new KubernetesService with fake clients for testing
 func NewKubernetesServiceWithFakeClient(testMode bool) (*KubernetesService, error) {
 	// Create empty structs for the clients
 	// We don't need real clients in test mode since we'll use mock data
-	return &KubernetesService{
+	s := &KubernetesService{
 		client:        nil,
 		metricsClient: nil,
 		testMode:      testMode,
 		firstRun:      true,
-	}, nil
+	}
+	s.collectCtx, s.abortCollect = context.WithCancel(context.Background())
+	s.stopped = make(chan struct{})
+	s.intervals = make(chan time.Duration, 1)
+
+	return s, nil
 }
 
+// StartInformers starts the pod, namespace, node, ReplicaSet and Job
+// informers, and the Event informer when events are recorded, which stop
+// when ctx is done. Readiness does not wait for events.
+func (s *KubernetesService) StartInformers(ctx context.Context) {
+	if s.informerFactory == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.informerStop = ctx.Done()
+	s.informerFactory.Start(s.informerStop)
+	s.mu.Unlock()
+
+	go func() {
+		if cache.WaitForCacheSync(ctx.Done(), s.cacheSynced...) {
+			log.Println("Kubernetes informer caches synced")
+		}
+	}()
+}
+
+// HasSynced reports whether the informer caches hold a full listing.
+func (s *KubernetesService) HasSynced() bool {
+	for _, synced := range s.cacheSynced {
+		if !synced() {
+			return false
+		}
+	}
+	return true
+}
+
+// SetFilter changes the namespaces and pods metrics are collected for,
+// starting with the next collection. A nil filter collects everything.
+func (s *KubernetesService) SetFilter(filter *CollectionFilter) {
+	s.mu.Lock()
+	s.filter = filter
+	s.mu.Unlock()
+}
+
+func (s *KubernetesService) collectionFilter() *CollectionFilter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.filter
+}
+
+// SetTagger changes the pod labels and annotations copied into tags,
+// starting with the next collection. A nil tagger copies none.
+func (s *KubernetesService) SetTagger(tagger *PodTagger) {
+	s.mu.Lock()
+	s.tagger = tagger
+	s.mu.Unlock()
+}
+
+func (s *KubernetesService) podTagger() *PodTagger {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tagger
+}
+
+// stripManagedFields drops managed fields before objects are cached, as
+// they are never read and make up a large part of each object.
+func stripManagedFields(obj interface{}) (interface{}, error) {
+	if accessor, err := meta.Accessor(obj); err == nil {
+		accessor.SetManagedFields(nil)
+	}
+	return obj, nil
+}
+
+// ListNamespaces returns the namespaces metrics are collected for.
 func (s *KubernetesService) ListNamespaces(ctx context.Context) ([]string, error) {
+	filter := s.collectionFilter()
+
 	// If in test mode with nil client, return mock namespaces
 	if s.client == nil {
-		return []string{"default", "kube-system", "monitoring", "database"}, nil
+		var namespaceList []string
+		for _, ns := range []string{"default", "kube-system", "monitoring", "database"} {
+			if filter.MatchNamespace(ns) {
+				namespaceList = append(namespaceList, ns)
+			}
+		}
+		return namespaceList, nil
+	}
+	if !s.HasSynced() {
+		return nil, ErrCacheNotSynced
 	}
 
-	namespaces, err := s.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	namespaces, err := s.namespaceLister.List(labels.Everything())
 	if err != nil {
 		return nil, err
 	}
 
 	var namespaceList []string
-	for _, ns := range namespaces.Items {
-		namespaceList = append(namespaceList, ns.Name)
+	for _, ns := range namespaces {
+		if filter.MatchNamespace(ns.Name) {
+			namespaceList = append(namespaceList, ns.Name)
+		}
 	}
+	sort.Strings(namespaceList)
 	return namespaceList, nil
 }
 
+// ListPods returns the pods metrics are collected for, in namespace or in
+// all namespaces if it is empty.
 func (s *KubernetesService) ListPods(ctx context.Context, namespace string) ([]models.Pod, error) {
+	filter := s.collectionFilter()
+
 	// If in test mode with nil client, return mock pods
 	if s.client == nil {
 		mockPods := []models.Pod{
@@ -81,35 +270,78 @@ func (s *KubernetesService) ListPods(ctx context.Context, namespace string) ([]m
 		}
 
 		// Filter by namespace if specified
-		if namespace != "" {
-			var filteredPods []models.Pod
-			for _, pod := range mockPods {
-				if pod.Namespace == namespace {
-					filteredPods = append(filteredPods, pod)
-				}
+		var filteredPods []models.Pod
+		for _, pod := range mockPods {
+			if (namespace == "" || pod.Namespace == namespace) && filter.MatchPod(pod.Namespace, mockPodLabels[pod.Name]) {
+				filteredPods = append(filteredPods, pod)
 			}
-			return filteredPods, nil
 		}
+		return filteredPods, nil
+	}
 
-		return mockPods, nil
+	if !s.HasSynced() {
+		return nil, ErrCacheNotSynced
 	}
 
-	var pods []models.Pod
-	podList, err := s.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	var podList []*corev1.Pod
+	var err error
+	if namespace != "" {
+		podList, err = s.podLister.Pods(namespace).List(filter.Selector())
+	} else {
+		podList, err = s.podLister.List(filter.Selector())
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	for _, pod := range podList.Items {
+	var pods []models.Pod
+	for _, pod := range podList {
+		if !filter.MatchNamespace(pod.Namespace) {
+			continue
+		}
 		pods = append(pods, models.Pod{
 			Name:      pod.Name,
 			Namespace: pod.Namespace,
 		})
 	}
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Namespace != pods[j].Namespace {
+			return pods[i].Namespace < pods[j].Namespace
+		}
+		return pods[i].Name < pods[j].Name
+	})
 	return pods, nil
 }
 
-func (s *KubernetesService) StartMetricsCollection(interval time.Duration, influxService *InfluxDBService) {
+func (s *KubernetesService) ListNodes(ctx context.Context) ([]string, error) {
+	// If in test mode with nil client, return mock nodes
+	if s.client == nil {
+		return []string{"node-1", "node-2", "node-3"}, nil
+	}
+
+	if !s.HasSynced() {
+		return nil, ErrCacheNotSynced
+	}
+
+	nodes, err := s.nodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	var nodeList []string
+	for _, node := range nodes {
+		nodeList = append(nodeList, node.Name)
+	}
+	sort.Strings(nodeList)
+	return nodeList, nil
+}
+
+// StartMetricsCollection collects metrics every interval until ctx is done.
+// A collection in progress at that point runs to completion; use Shutdown
+// to wait for it.
+func (s *KubernetesService) StartMetricsCollection(ctx context.Context, interval time.Duration, sink Sink) {
+	defer close(s.stopped)
+
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
@@ -118,34 +350,71 @@ func (s *KubernetesService) StartMetricsCollection(interval time.Duration, influ
 	// If in test mode, immediately collect mock metrics
 	if s.testMode && s.firstRun {
 		log.Println("Test mode enabled: collecting mock metrics for first run")
-		if err := s.collectMockMetrics(influxService); err != nil {
+		if err := s.collectMockMetrics(s.collectCtx, sink); err != nil {
 			log.Printf("Error collecting mock metrics: %v", err)
 		}
 		s.firstRun = false
 	}
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Stopping metrics collection")
+			return
+		case d := <-s.intervals:
+			if d != interval {
+				interval = d
+				ticker.Reset(interval)
+				log.Printf("Collecting metrics every %v", interval)
+			}
+			continue
+		case <-ticker.C:
+		}
+
 		if s.testMode && s.firstRun {
-			if err := s.collectMockMetrics(influxService); err != nil {
+			if err := s.collectMockMetrics(s.collectCtx, sink); err != nil {
 				log.Printf("Error collecting mock metrics: %v", err)
 			}
 			s.firstRun = false
 		} else {
-			if err := s.collectMetrics(influxService); err != nil {
+			if err := s.collectMetrics(s.collectCtx, sink); err != nil {
 				log.Printf("Error collecting metrics: %v", err)
 			}
 		}
 	}
 }
 
-func (s *KubernetesService) collectMetrics(influxService *InfluxDBService) error {
+// SetInterval changes the interval of a running collection loop. The
+// next collection happens one full interval after the change.
+func (s *KubernetesService) SetInterval(interval time.Duration) {
+	select {
+	case <-s.intervals:
+	default:
+	}
+	s.intervals <- interval
+}
+
+// Shutdown waits for the collection loop to return after its context is
+// done. If ctx expires first, the in-flight collection is aborted.
+func (s *KubernetesService) Shutdown(ctx context.Context) error {
+	select {
+	case <-s.stopped:
+		return nil
+	case <-ctx.Done():
+		log.Println("Aborting in-flight metrics collection")
+		s.abortCollect()
+		<-s.stopped
+		return ctx.Err()
+	}
+}
+
+func (s *KubernetesService) collectMetrics(ctx context.Context, sink Sink) error {
 	// If in test mode with nil clients, use mock metrics instead
 	if s.client == nil || s.metricsClient == nil {
-		return s.collectMockMetrics(influxService)
+		return s.collectMockMetrics(ctx, sink)
 	}
 
-	ctx := context.Background()
-	now := time.Now()
+	batch := &models.MetricsBatch{Time: time.Now()}
 
 	// Collect node metrics
 	nodeMetrics, err := s.metricsClient.MetricsV1beta1().NodeMetricses().List(ctx, metav1.ListOptions{})
@@ -153,121 +422,372 @@ func (s *KubernetesService) collectMetrics(influxService *InfluxDBService) error
 		return fmt.Errorf("error getting node metrics: %v", err)
 	}
 
-	// Collect pod metrics
-	podMetrics, err := s.metricsClient.MetricsV1beta1().PodMetricses("").List(ctx, metav1.ListOptions{})
+	// Collect pod metrics, selecting pods by label on the server
+	filter := s.collectionFilter()
+	podMetrics, err := s.metricsClient.MetricsV1beta1().PodMetricses("").List(ctx, metav1.ListOptions{
+		LabelSelector: filter.Selector().String(),
+	})
 	if err != nil {
 		return fmt.Errorf("error getting pod metrics: %v", err)
 	}
 
-	writeAPI := influxService.Client.WriteAPIBlocking(influxService.Org, influxService.Bucket)
-
-	// Write node metrics
-	for _, node := range nodeMetrics.Items {
-		p := influxdb2.NewPoint(
-			"node_metrics",
-			map[string]string{"node": node.Name},
-			map[string]interface{}{
-				"cpu_usage":    node.Usage.Cpu().MilliValue(),
-				"memory_usage": node.Usage.Memory().Value(),
-			},
-			now,
-		)
-		if err := writeAPI.WritePoint(ctx, p); err != nil {
-			log.Printf("Error writing node metrics: %v", err)
-		}
-	}
-
-	// Write pod metrics
-	for _, pod := range podMetrics.Items {
-		for _, container := range pod.Containers {
-			p := influxdb2.NewPoint(
-				"pod_metrics",
-				map[string]string{
-					"namespace": pod.Namespace,
-					"pod":       pod.Name,
-					"container": container.Name,
-				},
-				map[string]interface{}{
-					"cpu_usage":    container.Usage.Cpu().MilliValue(),
-					"memory_usage": container.Usage.Memory().Value(),
-				},
-				now,
-			)
-			if err := writeAPI.WritePoint(ctx, p); err != nil {
-				log.Printf("Error writing pod metrics: %v", err)
+	// Node and pod objects for capacity, resource requests and limits,
+	// owners and labels
+	synced := s.HasSynced()
+	if !synced {
+		log.Printf("Error getting node and pod specs: %v", ErrCacheNotSynced)
+	}
+
+	var runningPods map[string]int64
+	if synced {
+		if runningPods, err = s.runningPodsByNode(); err != nil {
+			log.Printf("Error counting running pods: %v", err)
+		}
+	}
+
+	for _, nodeMetric := range nodeMetrics.Items {
+		sample := models.NodeSample{
+			Node:        nodeMetric.Name,
+			CPUUsage:    nodeMetric.Usage.Cpu().MilliValue(),
+			MemoryUsage: nodeMetric.Usage.Memory().Value(),
+		}
+		if synced {
+			if node, err := s.nodeLister.Get(nodeMetric.Name); err == nil {
+				setNodeInfo(&sample, node)
+				sample.RunningPods = runningPods[node.Name]
 			}
 		}
+		batch.Nodes = append(batch.Nodes, sample)
 	}
 
-	return nil
+	tagger := s.podTagger()
+
+	for _, podMetric := range podMetrics.Items {
+		if !filter.MatchNamespace(podMetric.Namespace) {
+			continue
+		}
+
+		var pod *corev1.Pod
+		var workloadKind, workloadName string
+		var tags map[string]string
+		if synced {
+			if pod, err = s.podLister.Pods(podMetric.Namespace).Get(podMetric.Name); err == nil {
+				workloadKind, workloadName = s.podWorkload(pod)
+				tags = tagger.Tags(pod.Labels, pod.Annotations, batch.Time)
+			}
+		}
+
+		for _, container := range podMetric.Containers {
+			sample := models.ContainerSample{
+				Namespace:    podMetric.Namespace,
+				Pod:          podMetric.Name,
+				Container:    container.Name,
+				WorkloadKind: workloadKind,
+				WorkloadName: workloadName,
+				Tags:         tags,
+				CPUUsage:     container.Usage.Cpu().MilliValue(),
+				MemoryUsage:  container.Usage.Memory().Value(),
+			}
+
+			if r, ok := containerResources(pod, container.Name); ok {
+				sample.CPURequest = r.Requests.Cpu().MilliValue()
+				sample.CPULimit = r.Limits.Cpu().MilliValue()
+				sample.MemoryRequest = r.Requests.Memory().Value()
+				sample.MemoryLimit = r.Limits.Memory().Value()
+			}
+
+			batch.Containers = append(batch.Containers, sample)
+		}
+	}
+
+	if synced {
+		if batch.Statuses, err = s.containerStatuses(filter, tagger, batch.Time); err != nil {
+			log.Printf("Error getting pod statuses: %v", err)
+		}
+	}
+	batch.Events = s.takeEvents()
+
+	if options := s.summaryOptions(); options != nil {
+		s.collectSummaries(ctx, options, batch)
+	}
+
+	return sink.Write(ctx, batch)
+}
+
+// containerStatuses returns the status of every container of the cached
+// pods matching filter, including pods that have no usage yet. Init
+// containers are left out, except sidecars.
+func (s *KubernetesService) containerStatuses(filter *CollectionFilter, tagger *PodTagger, now time.Time) ([]models.ContainerStatusSample, error) {
+	pods, err := s.podLister.List(filter.Selector())
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []models.ContainerStatusSample
+	for _, pod := range pods {
+		if !filter.MatchNamespace(pod.Namespace) {
+			continue
+		}
+
+		workloadKind, workloadName := s.podWorkload(pod)
+		tags := tagger.Tags(pod.Labels, pod.Annotations, now)
+
+		var podReady bool
+		for _, c := range pod.Status.Conditions {
+			if c.Type == corev1.PodReady {
+				podReady = c.Status == corev1.ConditionTrue
+			}
+		}
+
+		statuses := make(map[string]corev1.ContainerStatus)
+		for _, status := range slices.Concat(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses) {
+			statuses[status.Name] = status
+		}
+
+		var containers []corev1.Container
+		for _, c := range pod.Spec.InitContainers {
+			if c.RestartPolicy != nil && *c.RestartPolicy == corev1.ContainerRestartPolicyAlways {
+				containers = append(containers, c)
+			}
+		}
+		containers = append(containers, pod.Spec.Containers...)
+
+		for _, c := range containers {
+			sample := models.ContainerStatusSample{
+				Namespace:    pod.Namespace,
+				Pod:          pod.Name,
+				Container:    c.Name,
+				WorkloadKind: workloadKind,
+				WorkloadName: workloadName,
+				Tags:         tags,
+				Phase:        string(pod.Status.Phase),
+				QOSClass:     string(pod.Status.QOSClass),
+				PodReady:     podReady,
+			}
+			// Containers not started yet have no status
+			if status, ok := statuses[c.Name]; ok {
+				sample.Ready = status.Ready
+				sample.RestartCount = int64(status.RestartCount)
+				if terminated := status.LastTerminationState.Terminated; terminated != nil {
+					sample.LastTerminationReason = terminated.Reason
+					sample.LastExitCode = int64(terminated.ExitCode)
+				}
+			}
+			samples = append(samples, sample)
+		}
+	}
+	return samples, nil
+}
+
+// runningPodsByNode counts the running pods on each node.
+func (s *KubernetesService) runningPodsByNode() (map[string]int64, error) {
+	pods, err := s.podLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	running := make(map[string]int64)
+	for _, pod := range pods {
+		if pod.Status.Phase == corev1.PodRunning && pod.Spec.NodeName != "" {
+			running[pod.Spec.NodeName]++
+		}
+	}
+	return running, nil
+}
+
+// setNodeInfo copies the capacity, allocatable resources, conditions and
+// topology labels of node into sample.
+func setNodeInfo(sample *models.NodeSample, node *corev1.Node) {
+	sample.CPUCapacity = node.Status.Capacity.Cpu().MilliValue()
+	sample.CPUAllocatable = node.Status.Allocatable.Cpu().MilliValue()
+	sample.MemoryCapacity = node.Status.Capacity.Memory().Value()
+	sample.MemoryAllocatable = node.Status.Allocatable.Memory().Value()
+	sample.PodCapacity = node.Status.Capacity.Pods().Value()
+	sample.PodAllocatable = node.Status.Allocatable.Pods().Value()
+
+	sample.Conditions = make(map[string]bool)
+	for _, c := range node.Status.Conditions {
+		sample.Conditions[string(c.Type)] = c.Status == corev1.ConditionTrue
+	}
+
+	var roles []string
+	for label := range node.Labels {
+		if role, ok := strings.CutPrefix(label, "node-role.kubernetes.io/"); ok && role != "" {
+			roles = append(roles, role)
+		}
+	}
+	if role := node.Labels["kubernetes.io/role"]; role != "" && !slices.Contains(roles, role) {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	sample.Role = strings.Join(roles, ",")
+
+	sample.Zone = firstLabel(node.Labels, corev1.LabelTopologyZone, corev1.LabelFailureDomainBetaZone)
+	sample.InstanceType = firstLabel(node.Labels, corev1.LabelInstanceTypeStable, corev1.LabelInstanceType)
+}
+
+// firstLabel returns the value of the first of keys set in labels.
+func firstLabel(labels map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if value := labels[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// containerResources returns the resource requirements of the named
+// container of pod, which may be nil.
+func containerResources(pod *corev1.Pod, name string) (corev1.ResourceRequirements, bool) {
+	if pod == nil {
+		return corev1.ResourceRequirements{}, false
+	}
+	// Sidecars run as restartable init containers and report usage too
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, container := range containers {
+			if container.Name == name {
+				return container.Resources, true
+			}
+		}
+	}
+	return corev1.ResourceRequirements{}, false
+}
+
+// podWorkload returns the kind and name of the top-level controller owning
+// pod, following ReplicaSets to their Deployment and Jobs to their
+// CronJob. Bare pods have no workload.
+func (s *KubernetesService) podWorkload(pod *corev1.Pod) (string, string) {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return "", ""
+	}
+
+	switch owner.Kind {
+	case "ReplicaSet":
+		rs, err := s.rsLister.ReplicaSets(pod.Namespace).Get(owner.Name)
+		if err == nil {
+			if parent := metav1.GetControllerOf(rs); parent != nil {
+				return parent.Kind, parent.Name
+			}
+			return owner.Kind, owner.Name
+		}
+		// ReplicaSets created since the last cache update are named after
+		// their Deployment and the pod template hash
+		if hash := pod.Labels["pod-template-hash"]; hash != "" && strings.HasSuffix(owner.Name, "-"+hash) {
+			return "Deployment", strings.TrimSuffix(owner.Name, "-"+hash)
+		}
+	case "Job":
+		if job, err := s.jobLister.Jobs(pod.Namespace).Get(owner.Name); err == nil {
+			if parent := metav1.GetControllerOf(job); parent != nil {
+				return parent.Kind, parent.Name
+			}
+		}
+	}
+	return owner.Kind, owner.Name
 }
 
 // New method to collect mock metrics
-func (s *KubernetesService) collectMockMetrics(influxService *InfluxDBService) error {
-	ctx := context.Background()
-	now := time.Now()
-	writeAPI := influxService.Client.WriteAPIBlocking(influxService.Org, influxService.Bucket)
-
-	// Mock node metrics
-	mockNodes := []struct {
-		name        string
-		cpuUsage    int64
-		memoryUsage int64
-	}{
-		{"node-1", 500, 4 * 1024 * 1024 * 1024},
-		{"node-2", 750, 6 * 1024 * 1024 * 1024},
-		{"node-3", 300, 2 * 1024 * 1024 * 1024},
-	}
-
-	for _, node := range mockNodes {
-		p := influxdb2.NewPoint(
-			"node_metrics",
-			map[string]string{"node": node.name},
-			map[string]interface{}{
-				"cpu_usage":    node.cpuUsage,
-				"memory_usage": node.memoryUsage,
-			},
-			now,
-		)
-		if err := writeAPI.WritePoint(ctx, p); err != nil {
-			log.Printf("Error writing mock node metrics: %v", err)
-		}
-	}
-
-	// Mock pod metrics
-	mockPods := []struct {
-		namespace     string
-		podName       string
-		containerName string
-		cpuUsage      int64
-		memoryUsage   int64
-	}{
-		{"default", "web-app-1", "web-container", 200, 512 * 1024 * 1024},
-		{"default", "web-app-1", "sidecar", 50, 128 * 1024 * 1024},
-		{"kube-system", "kube-dns-1", "dns", 100, 256 * 1024 * 1024},
-		{"monitoring", "prometheus-1", "prometheus", 300, 1024 * 1024 * 1024},
-		{"database", "postgres-1", "postgres", 400, 2 * 1024 * 1024 * 1024},
-	}
-
-	for _, pod := range mockPods {
-		p := influxdb2.NewPoint(
-			"pod_metrics",
-			map[string]string{
-				"namespace": pod.namespace,
-				"pod":       pod.podName,
-				"container": pod.containerName,
-			},
-			map[string]interface{}{
-				"cpu_usage":    pod.cpuUsage,
-				"memory_usage": pod.memoryUsage,
-			},
-			now,
-		)
-		if err := writeAPI.WritePoint(ctx, p); err != nil {
-			log.Printf("Error writing mock pod metrics: %v", err)
-		}
-	}
-
-	log.Println("Successfully wrote mock metrics to InfluxDB")
+func (s *KubernetesService) collectMockMetrics(ctx context.Context, sink Sink) error {
+	batch := &models.MetricsBatch{
+		Time: time.Now(),
+		// Mock node metrics
+		Nodes: []models.NodeSample{
+			{Node: "node-1", Role: "control-plane", Zone: "zone-a", InstanceType: "m5.xlarge", CPUUsage: 500, MemoryUsage: 4 * 1024 * 1024 * 1024,
+				CPUCapacity: 4000, CPUAllocatable: 3800, MemoryCapacity: 16 * 1024 * 1024 * 1024, MemoryAllocatable: 15 * 1024 * 1024 * 1024, PodCapacity: 110, PodAllocatable: 110, RunningPods: 2,
+				Conditions: map[string]bool{"Ready": true}},
+			{Node: "node-2", Zone: "zone-a", InstanceType: "m5.2xlarge", CPUUsage: 750, MemoryUsage: 6 * 1024 * 1024 * 1024,
+				CPUCapacity: 8000, CPUAllocatable: 7800, MemoryCapacity: 32 * 1024 * 1024 * 1024, MemoryAllocatable: 31 * 1024 * 1024 * 1024, PodCapacity: 110, PodAllocatable: 110, RunningPods: 1,
+				Conditions: map[string]bool{"Ready": true}},
+			{Node: "node-3", Zone: "zone-b", InstanceType: "m5.large", CPUUsage: 300, MemoryUsage: 2 * 1024 * 1024 * 1024,
+				CPUCapacity: 2000, CPUAllocatable: 1900, MemoryCapacity: 8 * 1024 * 1024 * 1024, MemoryAllocatable: 7 * 1024 * 1024 * 1024, PodCapacity: 110, PodAllocatable: 110, RunningPods: 1,
+				Conditions: map[string]bool{"Ready": true, "MemoryPressure": true}},
+		},
+		// Mock pod metrics
+		Containers: []models.ContainerSample{
+			{Namespace: "default", Pod: "web-app-1", Container: "web-container", CPUUsage: 200, MemoryUsage: 512 * 1024 * 1024, CPURequest: 250, CPULimit: 500, MemoryRequest: 512 * 1024 * 1024, MemoryLimit: 1024 * 1024 * 1024},
+			{Namespace: "default", Pod: "web-app-1", Container: "sidecar", CPUUsage: 50, MemoryUsage: 128 * 1024 * 1024, CPURequest: 50, MemoryRequest: 64 * 1024 * 1024, MemoryLimit: 128 * 1024 * 1024},
+			{Namespace: "kube-system", Pod: "kube-dns-1", Container: "dns", CPUUsage: 100, MemoryUsage: 256 * 1024 * 1024, CPURequest: 100, MemoryRequest: 70 * 1024 * 1024, MemoryLimit: 170 * 1024 * 1024},
+			{Namespace: "monitoring", Pod: "prometheus-1", Container: "prometheus", CPUUsage: 300, MemoryUsage: 1024 * 1024 * 1024, CPURequest: 500, CPULimit: 1000, MemoryRequest: 2 * 1024 * 1024 * 1024, MemoryLimit: 2 * 1024 * 1024 * 1024},
+			{Namespace: "database", Pod: "postgres-1", Container: "postgres", CPUUsage: 400, MemoryUsage: 2 * 1024 * 1024 * 1024},
+		},
+	}
+
+	filter := s.collectionFilter()
+	tagger := s.podTagger()
+	containers := batch.Containers[:0]
+	for _, c := range batch.Containers {
+		if filter.MatchPod(c.Namespace, mockPodLabels[c.Pod]) {
+			c.WorkloadKind, c.WorkloadName = mockPodWorkloads[c.Pod][0], mockPodWorkloads[c.Pod][1]
+			c.Tags = tagger.Tags(mockPodLabels[c.Pod], nil, batch.Time)
+			containers = append(containers, c)
+		}
+	}
+	batch.Containers = containers
+
+	// Every mock container is running; postgres runs out of memory every
+	// 20 collections
+	s.mockCollections++
+	for _, c := range batch.Containers {
+		status := models.ContainerStatusSample{
+			Namespace:    c.Namespace,
+			Pod:          c.Pod,
+			Container:    c.Container,
+			WorkloadKind: c.WorkloadKind,
+			WorkloadName: c.WorkloadName,
+			Tags:         c.Tags,
+			Phase:        string(corev1.PodRunning),
+			QOSClass:     string(corev1.PodQOSBurstable),
+			PodReady:     true,
+			Ready:        true,
+		}
+		if c.CPURequest == 0 && c.MemoryRequest == 0 {
+			status.QOSClass = string(corev1.PodQOSBestEffort)
+		}
+		if c.Pod == "postgres-1" {
+			status.RestartCount = int64(s.mockCollections / 20)
+			if status.RestartCount > 0 {
+				status.LastTerminationReason = "OOMKilled"
+				status.LastExitCode = 137
+			}
+			if s.mockCollections%20 == 0 {
+				s.recordEvent(mockEvent(c.Namespace, c.Pod, corev1.EventTypeWarning, "BackOff", "kubelet",
+					fmt.Sprintf("Back-off restarting failed container %s in pod %s", c.Container, c.Pod), batch.Time))
+			}
+		}
+		if s.mockCollections == 1 && (len(batch.Statuses) == 0 || batch.Statuses[len(batch.Statuses)-1].Pod != c.Pod) {
+			s.recordEvent(mockEvent(c.Namespace, c.Pod, corev1.EventTypeNormal, "Scheduled", "default-scheduler",
+				fmt.Sprintf("Successfully assigned %s/%s to %s", c.Namespace, c.Pod, mockPodNodes[c.Pod]), batch.Time))
+		}
+		batch.Statuses = append(batch.Statuses, status)
+	}
+	batch.Events = s.takeEvents()
+
+	// Mock nodes have no kubelet, but a stand-in may serve their summaries
+	if options := s.summaryOptions(); options != nil && options.URL != "" {
+		s.collectSummaries(ctx, options, batch)
+	}
+
+	if err := sink.Write(ctx, batch); err != nil {
+		return err
+	}
+
+	log.Println("Successfully wrote mock metrics")
 	return nil
 }
+
+// mockEvent returns an Event about a pod in test mode.
+func mockEvent(namespace, pod, eventType, reason, source, message string, t time.Time) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			UID:       types.UID(fmt.Sprintf("%s-%s-%s-%d", namespace, pod, reason, t.UnixNano())),
+		},
+		InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: namespace, Name: pod},
+		Type:           eventType,
+		Reason:         reason,
+		Message:        message,
+		Source:         corev1.EventSource{Component: source},
+		Count:          1,
+		LastTimestamp:  metav1.NewTime(t),
+	}
+}
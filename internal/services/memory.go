@@ -0,0 +1,375 @@
+package services
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stenstromen/tinykmetrics/internal/models"
+)
+
+// Querier answers the metrics queries behind /api/metrics, the restart
+// queries behind /api/restarts and the event queries behind /api/events.
+type Querier interface {
+	QueryMetrics(ctx context.Context, query models.MetricsQuery) (interface{}, error)
+	QueryNodeMetrics(ctx context.Context, query models.NodeMetricsQuery) (interface{}, error)
+	QueryRestarts(ctx context.Context, query models.RestartsQuery) ([]models.RestartEvent, error)
+	QueryEvents(ctx context.Context, query models.EventsQuery) ([]models.Event, error)
+}
+
+// MemoryStore is a sink that keeps the batches of the last retention in a
+// ring buffer and answers the same queries as InfluxDBService, so the
+// dashboard works without a database. Nothing survives a restart.
+type MemoryStore struct {
+	retention time.Duration
+
+	mu      sync.RWMutex
+	batches []*models.MetricsBatch // ring buffer, next is the oldest slot
+	next    int
+}
+
+// NewMemoryStore sizes the ring buffer to hold retention worth of batches
+// collected every interval.
+func NewMemoryStore(retention, interval time.Duration) *MemoryStore {
+	size := 1
+	if interval > 0 {
+		size = int(retention/interval) + 1
+	}
+	return &MemoryStore{
+		retention: retention,
+		batches:   make([]*models.MetricsBatch, size),
+	}
+}
+
+// Resize changes the retention, keeping the most recent batches that fit
+// the new ring buffer.
+func (s *MemoryStore) Resize(retention, interval time.Duration) {
+	resized := NewMemoryStore(retention, interval)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.batches {
+		if batch := s.batches[(s.next+i)%len(s.batches)]; batch != nil {
+			resized.batches[resized.next] = batch
+			resized.next = (resized.next + 1) % len(resized.batches)
+		}
+	}
+	s.retention = retention
+	s.batches = resized.batches
+	s.next = resized.next
+}
+
+func (s *MemoryStore) Write(ctx context.Context, batch *models.MetricsBatch) error {
+	s.mu.Lock()
+	s.batches[s.next] = batch
+	s.next = (s.next + 1) % len(s.batches)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// batchesIn returns the retained batches within timeRange, oldest first.
+func (s *MemoryStore) batchesIn(timeRange TimeRange) []*models.MetricsBatch {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cutoff := time.Now().Add(-s.retention)
+
+	var batches []*models.MetricsBatch
+	for i := range s.batches {
+		batch := s.batches[(s.next+i)%len(s.batches)]
+		if batch == nil || batch.Time.Before(cutoff) {
+			continue
+		}
+		if !batch.Time.Before(timeRange.Start) && batch.Time.Before(timeRange.Stop) {
+			batches = append(batches, batch)
+		}
+	}
+	return batches
+}
+
+func (s *MemoryStore) QueryMetrics(ctx context.Context, query models.MetricsQuery) (interface{}, error) {
+	timeRange, err := ParseTimeRange(query.Start, query.Stop, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	agg, err := ParseAggregation(query.Window, query.Aggregate, timeRange)
+	if err != nil {
+		return nil, err
+	}
+
+	if isWorkloadQuery(query) {
+		fn, err := parseWorkloadQuery(query)
+		if err != nil {
+			return nil, err
+		}
+
+		w := newWindowAggregator(timeRange, agg)
+		for _, batch := range s.batchesIn(timeRange) {
+			for _, c := range batch.Containers {
+				if c.Namespace != query.Namespace || c.WorkloadKind != query.WorkloadKind || c.WorkloadName != query.WorkloadName {
+					continue
+				}
+				w.add(batch.Time, []string{"namespace", c.Namespace, "pod", c.Pod, "container", c.Container}, containerFields(c))
+			}
+		}
+		return rollupWorkload(w.points(), fn, query.Namespace, query.WorkloadKind, query.WorkloadName), nil
+	}
+
+	if query.Namespace != "" {
+		if err := validateNamespace(query.Namespace); err != nil {
+			return nil, err
+		}
+	}
+	if query.Pod != "" {
+		if err := validatePodName(query.Pod); err != nil {
+			return nil, err
+		}
+	}
+
+	w := newWindowAggregator(timeRange, agg)
+	for _, batch := range s.batchesIn(timeRange) {
+		for _, c := range batch.Containers {
+			if (query.Namespace != "" && c.Namespace != query.Namespace) || (query.Pod != "" && c.Pod != query.Pod) {
+				continue
+			}
+			w.add(batch.Time, []string{"namespace", c.Namespace, "pod", c.Pod, "container", c.Container}, containerFields(c))
+		}
+	}
+
+	return w.points(), nil
+}
+
+func (s *MemoryStore) QueryNodeMetrics(ctx context.Context, query models.NodeMetricsQuery) (interface{}, error) {
+	timeRange, err := ParseTimeRange(query.Start, query.Stop, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	agg, err := ParseAggregation(query.Window, query.Aggregate, timeRange)
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Node != "" {
+		if err := validateNodeName(query.Node); err != nil {
+			return nil, err
+		}
+	}
+
+	w := newWindowAggregator(timeRange, agg)
+	for _, batch := range s.batchesIn(timeRange) {
+		for _, node := range batch.Nodes {
+			if query.Node != "" && node.Node != query.Node {
+				continue
+			}
+			w.add(batch.Time, []string{"node", node.Node}, nodeFields(node))
+		}
+	}
+
+	return w.points(), nil
+}
+
+func (s *MemoryStore) QueryRestarts(ctx context.Context, query models.RestartsQuery) ([]models.RestartEvent, error) {
+	timeRange, err := ParseTimeRange(query.Start, query.Stop, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Namespace != "" {
+		if err := validateNamespace(query.Namespace); err != nil {
+			return nil, err
+		}
+	}
+	if query.Pod != "" {
+		if err := validatePodName(query.Pod); err != nil {
+			return nil, err
+		}
+	}
+
+	tracker := newRestartTracker()
+	for _, batch := range s.batchesIn(timeRange) {
+		for _, status := range batch.Statuses {
+			if (query.Namespace != "" && status.Namespace != query.Namespace) || (query.Pod != "" && status.Pod != query.Pod) {
+				continue
+			}
+			tracker.add(batch.Time, status)
+		}
+	}
+
+	return tracker.result(), nil
+}
+
+func (s *MemoryStore) QueryEvents(ctx context.Context, query models.EventsQuery) ([]models.Event, error) {
+	timeRange, err := ParseTimeRange(query.Start, query.Stop, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Namespace != "" {
+		if err := validateNamespace(query.Namespace); err != nil {
+			return nil, err
+		}
+	}
+	if query.Pod != "" {
+		if err := validatePodName(query.Pod); err != nil {
+			return nil, err
+		}
+	}
+
+	// Events are carried by the first batch collected after them, which
+	// may be after the end of the range
+	var events []models.Event
+	for _, batch := range s.batchesIn(TimeRange{Start: timeRange.Start, Stop: time.Now().Add(time.Minute)}) {
+		for _, e := range batch.Events {
+			if e.Time.Before(timeRange.Start) || !e.Time.Before(timeRange.Stop) {
+				continue
+			}
+			if (query.Namespace != "" && e.Namespace != query.Namespace) || (query.Pod != "" && (e.Kind != "Pod" || e.Name != query.Pod)) {
+				continue
+			}
+			events = append(events, models.Event{
+				Time:      e.Time.UTC(),
+				Namespace: e.Namespace,
+				Kind:      e.Kind,
+				Name:      e.Name,
+				Type:      e.Type,
+				Reason:    e.Reason,
+				Message:   e.Message,
+				Count:     e.Count,
+				Source:    e.Source,
+			})
+		}
+	}
+
+	slices.SortStableFunc(events, func(a, b models.Event) int { return a.Time.Compare(b.Time) })
+	if len(events) > maxQueryEvents {
+		events = events[len(events)-maxQueryEvents:]
+	}
+	return events, nil
+}
+
+// windowAggregator reduces samples per series and field into windows
+// aligned to the Unix epoch, like aggregateWindow. Each window is
+// reported at its end, clipped to the end of the time range.
+type windowAggregator struct {
+	timeRange TimeRange
+	agg       Aggregation
+	series    map[string]*aggregatedSeries
+}
+
+type aggregatedSeries struct {
+	tags    []string // name/value pairs
+	field   string
+	windows map[int64]*windowValue // keyed by window start in ns
+}
+
+type windowValue struct {
+	sum, min, max, last float64
+	count               int
+}
+
+func newWindowAggregator(timeRange TimeRange, agg Aggregation) *windowAggregator {
+	return &windowAggregator{timeRange: timeRange, agg: agg, series: make(map[string]*aggregatedSeries)}
+}
+
+// add records the fields of a sample taken at t. Samples must be added in
+// time order for "last" to be correct.
+func (w *windowAggregator) add(t time.Time, tags []string, fields map[string]interface{}) {
+	ns := t.UnixNano()
+	start := ns - ns%int64(w.agg.Every)
+	prefix := strings.Join(tags, "\x00")
+
+	for field, raw := range fields {
+		var v float64
+		switch value := raw.(type) {
+		case int64:
+			v = float64(value)
+		case float64:
+			v = value
+		default:
+			continue
+		}
+
+		key := prefix + "\x00" + field
+		series, ok := w.series[key]
+		if !ok {
+			series = &aggregatedSeries{tags: tags, field: field, windows: make(map[int64]*windowValue)}
+			w.series[key] = series
+		}
+
+		wv, ok := series.windows[start]
+		if !ok {
+			wv = &windowValue{min: math.Inf(1), max: math.Inf(-1)}
+			series.windows[start] = wv
+		}
+		wv.sum += v
+		wv.min = math.Min(wv.min, v)
+		wv.max = math.Max(wv.max, v)
+		wv.last = v
+		wv.count++
+	}
+}
+
+// points returns the aggregated values in the shape of the InfluxDB
+// query results, ordered by series and time.
+func (w *windowAggregator) points() []map[string]interface{} {
+	keys := make([]string, 0, len(w.series))
+	for key := range w.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var points []map[string]interface{}
+	for _, key := range keys {
+		series := w.series[key]
+
+		starts := make([]int64, 0, len(series.windows))
+		for start := range series.windows {
+			starts = append(starts, start)
+		}
+		sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+		for _, start := range starts {
+			end := time.Unix(0, start+int64(w.agg.Every)).UTC()
+			if end.After(w.timeRange.Stop) {
+				end = w.timeRange.Stop.UTC()
+			}
+
+			point := map[string]interface{}{
+				"time":  end,
+				"value": series.windows[start].value(w.agg.Fn),
+				"field": series.field,
+			}
+			for i := 0; i < len(series.tags); i += 2 {
+				point[series.tags[i]] = series.tags[i+1]
+			}
+			points = append(points, point)
+		}
+	}
+	return points
+}
+
+func (v *windowValue) value(fn string) float64 {
+	switch fn {
+	case "max":
+		return v.max
+	case "min":
+		return v.min
+	case "last":
+		return v.last
+	case "sum":
+		return v.sum
+	default:
+		return v.sum / float64(v.count)
+	}
+}
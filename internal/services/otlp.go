@@ -0,0 +1,337 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/stenstromen/tinykmetrics/internal/models"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http/protobuf"
+)
+
+// otlpMaxResources caps the number of resources sent per export request.
+const otlpMaxResources = 1000
+
+// otlpScope identifies tinykmetrics as the instrumentation scope.
+var otlpScope = &commonpb.InstrumentationScope{Name: "github.com/stenstromen/tinykmetrics"}
+
+// OTLPOptions configures the OTLP exporter. For gRPC, Endpoint is a
+// host:port and Insecure disables TLS. For HTTP it is a URL, and
+// /v1/metrics is used when it has no path.
+type OTLPOptions struct {
+	Endpoint      string
+	Protocol      string
+	Headers       map[string]string
+	TLS           TLSOptions
+	Insecure      bool
+	Timeout       time.Duration
+	MaxRetries    int
+	RetryInterval time.Duration
+}
+
+// OTLPSink exports node and container usage as OTLP gauges. Every node
+// and container is a resource described by the k8s semantic-convention
+// attributes.
+type OTLPSink struct {
+	options OTLPOptions
+
+	// Set for gRPC
+	conn   *grpc.ClientConn
+	client colmetricspb.MetricsServiceClient
+
+	// Set for HTTP
+	url        string
+	httpClient *http.Client
+}
+
+func NewOTLPSink(options OTLPOptions) (*OTLPSink, error) {
+	tlsConfig, err := newTLSConfig(options.TLS)
+	if err != nil {
+		return nil, err
+	}
+	if options.RetryInterval <= 0 {
+		options.RetryInterval = time.Second
+	}
+
+	s := &OTLPSink{options: options}
+
+	switch options.Protocol {
+	case OTLPProtocolGRPC:
+		creds := credentials.NewTLS(tlsConfig)
+		if options.Insecure {
+			creds = insecure.NewCredentials()
+		}
+		s.conn, err = grpc.NewClient(options.Endpoint, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, fmt.Errorf("error creating OTLP gRPC client: %v", err)
+		}
+		s.client = colmetricspb.NewMetricsServiceClient(s.conn)
+	case OTLPProtocolHTTP:
+		u, err := url.Parse(options.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid OTLP HTTP endpoint %q", options.Endpoint)
+		}
+		if u.Path == "" || u.Path == "/" {
+			u.Path = "/v1/metrics"
+		}
+		s.url = u.String()
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		s.httpClient = &http.Client{Transport: transport, Timeout: options.Timeout}
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %q", options.Protocol)
+	}
+
+	return s, nil
+}
+
+func (s *OTLPSink) Write(ctx context.Context, batch *models.MetricsBatch) error {
+	resources := otlpResourceMetrics(batch)
+
+	// A failed request does not stop the others
+	var errs []error
+	for len(resources) > 0 {
+		n := min(otlpMaxResources, len(resources))
+		req := &colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: resources[:n]}
+
+		export := s.exportHTTP
+		if s.client != nil {
+			export = s.exportGRPC
+		}
+		err := retry(ctx, "OTLP export", s.options.MaxRetries, s.options.RetryInterval, func() (bool, error) {
+			return export(ctx, req)
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error exporting OTLP metrics: %v", err))
+		}
+		resources = resources[n:]
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *OTLPSink) Close() error {
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	s.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// exportGRPC sends req once and reports whether a failure is worth
+// retrying, following the OTLP retry rules for gRPC status codes.
+func (s *OTLPSink) exportGRPC(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (bool, error) {
+	if len(s.options.Headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(s.options.Headers))
+	}
+	if s.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.options.Timeout)
+		defer cancel()
+	}
+
+	resp, err := s.client.Export(ctx, req)
+	if err != nil {
+		switch status.Code(err) {
+		case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded, codes.Aborted, codes.DataLoss:
+			return true, err
+		}
+		return false, err
+	}
+
+	logPartialSuccess(resp.GetPartialSuccess())
+	return false, nil
+}
+
+// exportHTTP sends req once and reports whether a failure is worth
+// retrying, following the OTLP retry rules for HTTP status codes.
+func (s *OTLPSink) exportHTTP(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (bool, error) {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return false, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("User-Agent", "tinykmetrics")
+	for k, v := range s.options.Headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	resp, err := s.httpClient.Do(httpReq)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode/100 == 2 {
+		var exportResp colmetricspb.ExportMetricsServiceResponse
+		if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/x-protobuf") &&
+			proto.Unmarshal(respBody, &exportResp) == nil {
+			logPartialSuccess(exportResp.GetPartialSuccess())
+		}
+		return false, nil
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true, fmt.Errorf("server returned %s", resp.Status)
+	}
+	return false, fmt.Errorf("server returned %s", resp.Status)
+}
+
+func logPartialSuccess(partial *colmetricspb.ExportMetricsPartialSuccess) {
+	if partial.GetRejectedDataPoints() > 0 || partial.GetErrorMessage() != "" {
+		log.Printf("OTLP endpoint rejected %d data points: %s",
+			partial.GetRejectedDataPoints(), partial.GetErrorMessage())
+	}
+}
+
+// otlpWorkloadAttributes are the resource attributes naming each kind of
+// workload.
+var otlpWorkloadAttributes = map[string]string{
+	"Deployment":  "k8s.deployment.name",
+	"ReplicaSet":  "k8s.replicaset.name",
+	"StatefulSet": "k8s.statefulset.name",
+	"DaemonSet":   "k8s.daemonset.name",
+	"Job":         "k8s.job.name",
+	"CronJob":     "k8s.cronjob.name",
+}
+
+// otlpResourceMetrics converts a batch into one resource per node and per
+// container.
+func otlpResourceMetrics(batch *models.MetricsBatch) []*metricspb.ResourceMetrics {
+	ts := uint64(batch.Time.UnixNano())
+	resources := make([]*metricspb.ResourceMetrics, 0, len(batch.Nodes)+len(batch.Containers))
+
+	for _, node := range batch.Nodes {
+		metrics := []*metricspb.Metric{
+			otlpCPUGauge("k8s.node.cpu.usage", "Node CPU usage.", node.CPUUsage, ts),
+			otlpBytesGauge("k8s.node.memory.usage", "Node memory usage.", node.MemoryUsage, ts),
+		}
+		// Allocatable resources are unknown until the node is cached
+		if node.CPUAllocatable > 0 {
+			metrics = append(metrics, otlpCPUGauge("k8s.node.allocatable.cpu", "Amount of CPU allocatable on the node.", node.CPUAllocatable, ts))
+		}
+		if node.MemoryAllocatable > 0 {
+			metrics = append(metrics, otlpBytesGauge("k8s.node.allocatable.memory", "Amount of memory allocatable on the node.", node.MemoryAllocatable, ts))
+		}
+		if node.PodAllocatable > 0 {
+			metrics = append(metrics, otlpGauge("k8s.node.allocatable.pods", "Amount of pods allocatable on the node.", "{pod}", &metricspb.NumberDataPoint{
+				TimeUnixNano: ts,
+				Value:        &metricspb.NumberDataPoint_AsInt{AsInt: node.PodAllocatable},
+			}))
+		}
+
+		attrs := []string{"k8s.node.name", node.Node}
+		if node.Zone != "" {
+			attrs = append(attrs, "cloud.availability_zone", node.Zone)
+		}
+		if node.InstanceType != "" {
+			attrs = append(attrs, "host.type", node.InstanceType)
+		}
+		resources = append(resources, otlpResource(attrs, metrics...))
+	}
+
+	for _, c := range batch.Containers {
+		metrics := []*metricspb.Metric{
+			otlpCPUGauge("container.cpu.usage", "Container CPU usage.", c.CPUUsage, ts),
+			otlpBytesGauge("container.memory.usage", "Container memory usage.", c.MemoryUsage, ts),
+		}
+		// Unset requests and limits are left out
+		if c.CPURequest > 0 {
+			metrics = append(metrics, otlpCPUGauge("k8s.container.cpu.request", "CPU resource requested for the container.", c.CPURequest, ts))
+		}
+		if c.CPULimit > 0 {
+			metrics = append(metrics, otlpCPUGauge("k8s.container.cpu.limit", "Maximum CPU resource limit set for the container.", c.CPULimit, ts))
+		}
+		if c.MemoryRequest > 0 {
+			metrics = append(metrics, otlpBytesGauge("k8s.container.memory.request", "Memory resource requested for the container.", c.MemoryRequest, ts))
+		}
+		if c.MemoryLimit > 0 {
+			metrics = append(metrics, otlpBytesGauge("k8s.container.memory.limit", "Maximum memory resource limit set for the container.", c.MemoryLimit, ts))
+		}
+
+		attrs := []string{"k8s.namespace.name", c.Namespace, "k8s.pod.name", c.Pod, "k8s.container.name", c.Container}
+		if attr, ok := otlpWorkloadAttributes[c.WorkloadKind]; ok {
+			attrs = append(attrs, attr, c.WorkloadName)
+		}
+		for _, name := range slices.Sorted(maps.Keys(c.Tags)) {
+			attrs = append(attrs, name, c.Tags[name])
+		}
+		resources = append(resources, otlpResource(attrs, metrics...))
+	}
+
+	return resources
+}
+
+// otlpResource builds a resource from attribute name/value pairs.
+func otlpResource(attrs []string, metrics ...*metricspb.Metric) *metricspb.ResourceMetrics {
+	resource := &resourcepb.Resource{}
+	for i := 0; i < len(attrs); i += 2 {
+		resource.Attributes = append(resource.Attributes, &commonpb.KeyValue{
+			Key:   attrs[i],
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: attrs[i+1]}},
+		})
+	}
+
+	return &metricspb.ResourceMetrics{
+		Resource:     resource,
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Scope: otlpScope, Metrics: metrics}},
+	}
+}
+
+// otlpCPUGauge converts millicores into a gauge in cores.
+func otlpCPUGauge(name, description string, millicores int64, ts uint64) *metricspb.Metric {
+	return otlpGauge(name, description, "{cpu}", &metricspb.NumberDataPoint{
+		TimeUnixNano: ts,
+		Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: float64(millicores) / 1000},
+	})
+}
+
+func otlpBytesGauge(name, description string, bytes int64, ts uint64) *metricspb.Metric {
+	return otlpGauge(name, description, "By", &metricspb.NumberDataPoint{
+		TimeUnixNano: ts,
+		Value:        &metricspb.NumberDataPoint_AsInt{AsInt: bytes},
+	})
+}
+
+func otlpGauge(name, description, unit string, point *metricspb.NumberDataPoint) *metricspb.Metric {
+	return &metricspb.Metric{
+		Name:        name,
+		Description: description,
+		Unit:        unit,
+		Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+			DataPoints: []*metricspb.NumberDataPoint{point},
+		}},
+	}
+}
@@ -0,0 +1,403 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stenstromen/tinykmetrics/internal/models"
+)
+
+const (
+	PrometheusContentType  = "text/plain; version=0.0.4; charset=utf-8"
+	OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// promLabelEscaper escapes label values for the text exposition formats.
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promFamily is a gauge or counter family rendered from a batch. Counter
+// names end in _total.
+type promFamily struct {
+	name    string
+	help    string
+	counter bool
+	samples func(batch *models.MetricsBatch, emit func(labels []string, value float64))
+}
+
+// promFamilies are the families exported from each batch. Labels are
+// passed as name/value pairs.
+var promFamilies = []promFamily{
+	nodeFamily("tinykmetrics_node_cpu_usage_cores", "CPU usage of the node in cores.", false,
+		func(n models.NodeSample) float64 { return float64(n.CPUUsage) / 1000 }),
+	nodeFamily("tinykmetrics_node_memory_usage_bytes", "Memory usage of the node in bytes.", false,
+		func(n models.NodeSample) float64 { return float64(n.MemoryUsage) }),
+	nodeFamily("tinykmetrics_node_cpu_capacity_cores", "CPU capacity of the node in cores.", true,
+		func(n models.NodeSample) float64 { return float64(n.CPUCapacity) / 1000 }),
+	nodeFamily("tinykmetrics_node_cpu_allocatable_cores", "CPU of the node allocatable to pods in cores.", true,
+		func(n models.NodeSample) float64 { return float64(n.CPUAllocatable) / 1000 }),
+	nodeFamily("tinykmetrics_node_memory_capacity_bytes", "Memory capacity of the node in bytes.", true,
+		func(n models.NodeSample) float64 { return float64(n.MemoryCapacity) }),
+	nodeFamily("tinykmetrics_node_memory_allocatable_bytes", "Memory of the node allocatable to pods in bytes.", true,
+		func(n models.NodeSample) float64 { return float64(n.MemoryAllocatable) }),
+	nodeFamily("tinykmetrics_node_pod_capacity", "Number of pods the node can run.", true,
+		func(n models.NodeSample) float64 { return float64(n.PodCapacity) }),
+	nodeFamily("tinykmetrics_node_pod_allocatable", "Number of pods that can be scheduled on the node.", true,
+		func(n models.NodeSample) float64 { return float64(n.PodAllocatable) }),
+	{
+		name: "tinykmetrics_node_running_pods",
+		help: "Number of pods running on the node.",
+		samples: func(batch *models.MetricsBatch, emit func([]string, float64)) {
+			for _, node := range batch.Nodes {
+				if node.PodCapacity > 0 {
+					emit(nodeLabels(node), float64(node.RunningPods))
+				}
+			}
+		},
+	},
+	{
+		name: "tinykmetrics_node_condition",
+		help: "Whether the node condition is true.",
+		samples: func(batch *models.MetricsBatch, emit func([]string, float64)) {
+			for _, node := range batch.Nodes {
+				if node.Conditions == nil {
+					continue
+				}
+				for _, c := range nodeConditions {
+					var value float64
+					if node.Conditions[c.condition] {
+						value = 1
+					}
+					emit(append(nodeLabels(node), "condition", c.condition), value)
+				}
+			}
+		},
+	},
+	containerFamily("tinykmetrics_container_cpu_usage_cores", "CPU usage of the container in cores.", false,
+		func(c models.ContainerSample) float64 { return float64(c.CPUUsage) / 1000 }),
+	containerFamily("tinykmetrics_container_memory_usage_bytes", "Memory usage of the container in bytes.", false,
+		func(c models.ContainerSample) float64 { return float64(c.MemoryUsage) }),
+	containerFamily("tinykmetrics_container_cpu_request_cores", "CPU request of the container in cores.", true,
+		func(c models.ContainerSample) float64 { return float64(c.CPURequest) / 1000 }),
+	containerFamily("tinykmetrics_container_cpu_limit_cores", "CPU limit of the container in cores.", true,
+		func(c models.ContainerSample) float64 { return float64(c.CPULimit) / 1000 }),
+	containerFamily("tinykmetrics_container_memory_request_bytes", "Memory request of the container in bytes.", true,
+		func(c models.ContainerSample) float64 { return float64(c.MemoryRequest) }),
+	containerFamily("tinykmetrics_container_memory_limit_bytes", "Memory limit of the container in bytes.", true,
+		func(c models.ContainerSample) float64 { return float64(c.MemoryLimit) }),
+	nodeStatsFamily("tinykmetrics_node_memory_working_set_bytes", "Working set memory of the node in bytes.",
+		func(s *models.NodeStats) int64 { return s.MemoryWorkingSet }),
+	nodeStatsFamily("tinykmetrics_node_memory_rss_bytes", "Resident set size memory of the node in bytes.",
+		func(s *models.NodeStats) int64 { return s.MemoryRSS }),
+	nodeStatsFamily("tinykmetrics_node_rootfs_used_bytes", "Used bytes of the node root filesystem.",
+		func(s *models.NodeStats) int64 { return s.RootfsUsed }),
+	nodeStatsFamily("tinykmetrics_node_rootfs_capacity_bytes", "Capacity of the node root filesystem in bytes.",
+		func(s *models.NodeStats) int64 { return s.RootfsCapacity }),
+	nodeStatsFamily("tinykmetrics_node_imagefs_used_bytes", "Used bytes of the node image filesystem.",
+		func(s *models.NodeStats) int64 { return s.ImagefsUsed }),
+	nodeStatsFamily("tinykmetrics_node_imagefs_capacity_bytes", "Capacity of the node image filesystem in bytes.",
+		func(s *models.NodeStats) int64 { return s.ImagefsCapacity }),
+	nodeNetworkFamily("tinykmetrics_node_network_receive_bytes_total", "Bytes received by the node.",
+		func(n *models.NetworkStats) int64 { return n.RxBytes }),
+	nodeNetworkFamily("tinykmetrics_node_network_receive_errors_total", "Receive errors of the node.",
+		func(n *models.NetworkStats) int64 { return n.RxErrors }),
+	nodeNetworkFamily("tinykmetrics_node_network_transmit_bytes_total", "Bytes transmitted by the node.",
+		func(n *models.NetworkStats) int64 { return n.TxBytes }),
+	nodeNetworkFamily("tinykmetrics_node_network_transmit_errors_total", "Transmit errors of the node.",
+		func(n *models.NetworkStats) int64 { return n.TxErrors }),
+	containerStatsFamily("tinykmetrics_container_memory_working_set_bytes", "Working set memory of the container in bytes.",
+		func(s *models.ContainerStats) int64 { return s.MemoryWorkingSet }),
+	containerStatsFamily("tinykmetrics_container_memory_rss_bytes", "Resident set size memory of the container in bytes.",
+		func(s *models.ContainerStats) int64 { return s.MemoryRSS }),
+	containerStatsFamily("tinykmetrics_container_rootfs_used_bytes", "Bytes used by the container's writable layer.",
+		func(s *models.ContainerStats) int64 { return s.RootfsUsed }),
+	containerStatsFamily("tinykmetrics_container_logs_used_bytes", "Bytes used by the container's logs.",
+		func(s *models.ContainerStats) int64 { return s.LogsUsed }),
+	podNetworkFamily("tinykmetrics_pod_network_receive_bytes_total", "Bytes received by the pod.",
+		func(n *models.NetworkStats) int64 { return n.RxBytes }),
+	podNetworkFamily("tinykmetrics_pod_network_receive_errors_total", "Receive errors of the pod.",
+		func(n *models.NetworkStats) int64 { return n.RxErrors }),
+	podNetworkFamily("tinykmetrics_pod_network_transmit_bytes_total", "Bytes transmitted by the pod.",
+		func(n *models.NetworkStats) int64 { return n.TxBytes }),
+	podNetworkFamily("tinykmetrics_pod_network_transmit_errors_total", "Transmit errors of the pod.",
+		func(n *models.NetworkStats) int64 { return n.TxErrors }),
+	{
+		name: "tinykmetrics_pod_ephemeral_storage_used_bytes",
+		help: "Ephemeral storage used by the pod in bytes.",
+		samples: func(batch *models.MetricsBatch, emit func([]string, float64)) {
+			for _, p := range batch.Pods {
+				emit(podLabels(p), float64(p.EphemeralStorageUsed))
+			}
+		},
+	},
+	volumeFamily("tinykmetrics_volume_used_bytes", "Used bytes of the persistent volume.",
+		func(v models.VolumeSample) int64 { return v.Used }),
+	volumeFamily("tinykmetrics_volume_capacity_bytes", "Capacity of the persistent volume in bytes.",
+		func(v models.VolumeSample) int64 { return v.Capacity }),
+	volumeFamily("tinykmetrics_volume_available_bytes", "Available bytes of the persistent volume.",
+		func(v models.VolumeSample) int64 { return v.Available }),
+	{
+		name:    "tinykmetrics_container_restarts_total",
+		help:    "Number of times the container has restarted.",
+		counter: true,
+		samples: func(batch *models.MetricsBatch, emit func([]string, float64)) {
+			for _, s := range batch.Statuses {
+				emit(statusLabels(s), float64(s.RestartCount))
+			}
+		},
+	},
+	{
+		name: "tinykmetrics_container_ready",
+		help: "Whether the container is ready.",
+		samples: func(batch *models.MetricsBatch, emit func([]string, float64)) {
+			for _, s := range batch.Statuses {
+				emit(statusLabels(s), float64(boolField(s.Ready)))
+			}
+		},
+	},
+	{
+		name: "tinykmetrics_container_last_termination_exit_code",
+		help: "Exit code of the last termination of the container, by reason.",
+		samples: func(batch *models.MetricsBatch, emit func([]string, float64)) {
+			for _, s := range batch.Statuses {
+				if s.LastTerminationReason != "" || s.LastExitCode != 0 {
+					emit(append(statusLabels(s), "reason", s.LastTerminationReason), float64(s.LastExitCode))
+				}
+			}
+		},
+	},
+	{
+		name: "tinykmetrics_pod_phase",
+		help: "Phase of the pod, always 1, with its QoS class.",
+		samples: func(batch *models.MetricsBatch, emit func([]string, float64)) {
+			for _, s := range podStatuses(batch) {
+				emit(append(podStatusLabels(s), "phase", s.Phase, "qos_class", s.QOSClass), 1)
+			}
+		},
+	},
+	{
+		name: "tinykmetrics_pod_ready",
+		help: "Whether the pod is ready.",
+		samples: func(batch *models.MetricsBatch, emit func([]string, float64)) {
+			for _, s := range podStatuses(batch) {
+				emit(podStatusLabels(s), float64(boolField(s.PodReady)))
+			}
+		},
+	},
+	{
+		name: "tinykmetrics_last_collection_timestamp_seconds",
+		help: "Unix time of the collection the samples were taken from.",
+		samples: func(batch *models.MetricsBatch, emit func([]string, float64)) {
+			emit(nil, float64(batch.Time.UnixNano())/1e9)
+		},
+	},
+}
+
+// nodeFamily exports value for every node. With skipZero set, zero values
+// are left out so capacities of unknown nodes are not exported.
+func nodeFamily(name, help string, skipZero bool, value func(models.NodeSample) float64) promFamily {
+	return promFamily{
+		name: name,
+		help: help,
+		samples: func(batch *models.MetricsBatch, emit func([]string, float64)) {
+			for _, n := range batch.Nodes {
+				if v := value(n); v != 0 || !skipZero {
+					emit(nodeLabels(n), v)
+				}
+			}
+		},
+	}
+}
+
+// nodeStatsFamily exports a kubelet summary statistic for every node that
+// reported it.
+func nodeStatsFamily(name, help string, value func(*models.NodeStats) int64) promFamily {
+	return promFamily{
+		name: name,
+		help: help,
+		samples: func(batch *models.MetricsBatch, emit func([]string, float64)) {
+			for _, n := range batch.Nodes {
+				if n.Stats != nil && value(n.Stats) > 0 {
+					emit(nodeLabels(n), float64(value(n.Stats)))
+				}
+			}
+		},
+	}
+}
+
+// nodeNetworkFamily exports a network counter for every node that
+// reported it.
+func nodeNetworkFamily(name, help string, value func(*models.NetworkStats) int64) promFamily {
+	return promFamily{
+		name:    name,
+		help:    help,
+		counter: true,
+		samples: func(batch *models.MetricsBatch, emit func([]string, float64)) {
+			for _, n := range batch.Nodes {
+				if n.Stats != nil && n.Stats.Network != nil {
+					emit(nodeLabels(n), float64(value(n.Stats.Network)))
+				}
+			}
+		},
+	}
+}
+
+// containerStatsFamily exports a kubelet summary statistic for every
+// container that reported it.
+func containerStatsFamily(name, help string, value func(*models.ContainerStats) int64) promFamily {
+	return promFamily{
+		name: name,
+		help: help,
+		samples: func(batch *models.MetricsBatch, emit func([]string, float64)) {
+			for _, c := range batch.Containers {
+				if c.Stats != nil && value(c.Stats) > 0 {
+					emit(containerLabels(c), float64(value(c.Stats)))
+				}
+			}
+		},
+	}
+}
+
+// podNetworkFamily exports a network counter for every pod that reported
+// it.
+func podNetworkFamily(name, help string, value func(*models.NetworkStats) int64) promFamily {
+	return promFamily{
+		name:    name,
+		help:    help,
+		counter: true,
+		samples: func(batch *models.MetricsBatch, emit func([]string, float64)) {
+			for _, p := range batch.Pods {
+				if p.Network != nil {
+					emit(podLabels(p), float64(value(p.Network)))
+				}
+			}
+		},
+	}
+}
+
+func volumeFamily(name, help string, value func(models.VolumeSample) int64) promFamily {
+	return promFamily{
+		name: name,
+		help: help,
+		samples: func(batch *models.MetricsBatch, emit func([]string, float64)) {
+			for _, v := range batch.Volumes {
+				emit(volumeLabels(v), float64(value(v)))
+			}
+		},
+	}
+}
+
+// containerFamily exports value for every container. With skipZero set,
+// zero values are left out so unset requests and limits are not exported.
+func containerFamily(name, help string, skipZero bool, value func(models.ContainerSample) float64) promFamily {
+	return promFamily{
+		name: name,
+		help: help,
+		samples: func(batch *models.MetricsBatch, emit func([]string, float64)) {
+			for _, c := range batch.Containers {
+				if v := value(c); v != 0 || !skipZero {
+					emit(containerLabels(c), v)
+				}
+			}
+		},
+	}
+}
+
+// podStatuses returns the status of the first container of each pod,
+// which carries the phase, readiness and QoS class of the pod. The
+// containers of a pod are adjacent in batch.Statuses.
+func podStatuses(batch *models.MetricsBatch) []models.ContainerStatusSample {
+	var pods []models.ContainerStatusSample
+	for i, s := range batch.Statuses {
+		if i == 0 || s.Namespace != batch.Statuses[i-1].Namespace || s.Pod != batch.Statuses[i-1].Pod {
+			pods = append(pods, s)
+		}
+	}
+	return pods
+}
+
+// PrometheusExporter is a sink that keeps the most recent batch for the
+// Prometheus /metrics endpoint. Each batch replaces the previous one, so
+// deleted pods and nodes disappear after the next collection, and nothing
+// is exported once the latest batch is older than staleAfter.
+type PrometheusExporter struct {
+	staleAfter time.Duration
+
+	mu    sync.RWMutex
+	batch *models.MetricsBatch
+}
+
+func NewPrometheusExporter(staleAfter time.Duration) *PrometheusExporter {
+	return &PrometheusExporter{staleAfter: staleAfter}
+}
+
+// SetStaleAfter changes how long the latest batch is exported.
+func (e *PrometheusExporter) SetStaleAfter(staleAfter time.Duration) {
+	e.mu.Lock()
+	e.staleAfter = staleAfter
+	e.mu.Unlock()
+}
+
+func (e *PrometheusExporter) Write(ctx context.Context, batch *models.MetricsBatch) error {
+	e.mu.Lock()
+	e.batch = batch
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *PrometheusExporter) Close() error {
+	return nil
+}
+
+// WriteMetrics renders the latest batch in the Prometheus text format, or
+// in OpenMetrics format when openMetrics is set.
+func (e *PrometheusExporter) WriteMetrics(w io.Writer, openMetrics bool) error {
+	e.mu.RLock()
+	batch, staleAfter := e.batch, e.staleAfter
+	e.mu.RUnlock()
+
+	if batch != nil && staleAfter > 0 && time.Since(batch.Time) > staleAfter {
+		batch = nil
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, family := range promFamilies {
+		// OpenMetrics names counter families without the _total suffix
+		name, typ := family.name, "gauge"
+		if family.counter {
+			typ = "counter"
+			if openMetrics {
+				name = strings.TrimSuffix(name, "_total")
+			}
+		}
+		bw.WriteString("# HELP " + name + " " + family.help + "\n")
+		bw.WriteString("# TYPE " + name + " " + typ + "\n")
+		if batch == nil {
+			continue
+		}
+
+		family.samples(batch, func(labels []string, value float64) {
+			bw.WriteString(family.name)
+			if len(labels) > 0 {
+				bw.WriteByte('{')
+				for i := 0; i < len(labels); i += 2 {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					bw.WriteString(labels[i] + `="` + promLabelEscaper.Replace(labels[i+1]) + `"`)
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteString(" " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+		})
+	}
+	if openMetrics {
+		bw.WriteString("# EOF\n")
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/stenstromen/tinykmetrics/internal/models"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// remoteWriteMaxSeries caps the number of series sent per request.
+const remoteWriteMaxSeries = 2000
+
+// RemoteWriteOptions configures the Prometheus remote-write sink. Basic
+// auth is used when Username is set, otherwise BearerToken if set.
+type RemoteWriteOptions struct {
+	URL           string
+	Headers       map[string]string
+	Username      string
+	Password      string
+	BearerToken   string
+	TLS           TLSOptions
+	Timeout       time.Duration
+	MaxRetries    int
+	RetryInterval time.Duration
+}
+
+// RemoteWriteSink sends samples to a Prometheus remote-write endpoint
+// such as Prometheus, Mimir or VictoriaMetrics, using the same series as
+// the /metrics endpoint.
+type RemoteWriteSink struct {
+	options RemoteWriteOptions
+	client  *http.Client
+}
+
+func NewRemoteWriteSink(options RemoteWriteOptions) (*RemoteWriteSink, error) {
+	tlsConfig, err := newTLSConfig(options.TLS)
+	if err != nil {
+		return nil, err
+	}
+	if options.RetryInterval <= 0 {
+		options.RetryInterval = time.Second
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &RemoteWriteSink{
+		options: options,
+		client:  &http.Client{Transport: transport, Timeout: options.Timeout},
+	}, nil
+}
+
+func (s *RemoteWriteSink) Write(ctx context.Context, batch *models.MetricsBatch) error {
+	series := remoteWriteSeries(batch)
+	timestamp := batch.Time.UnixMilli()
+
+	// A failed request does not stop the others
+	var errs []error
+	for len(series) > 0 {
+		n := min(remoteWriteMaxSeries, len(series))
+		body := snappy.Encode(nil, encodeWriteRequest(series[:n], timestamp))
+		err := retry(ctx, "remote write request", s.options.MaxRetries, s.options.RetryInterval, func() (bool, error) {
+			return s.post(ctx, body)
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error sending remote write request: %v", err))
+		}
+		series = series[n:]
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *RemoteWriteSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// post sends body once and reports whether a failure is worth retrying.
+func (s *RemoteWriteSink) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.options.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "tinykmetrics")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for k, v := range s.options.Headers {
+		req.Header.Set(k, v)
+	}
+	if s.options.Username != "" {
+		req.SetBasicAuth(s.options.Username, s.options.Password)
+	} else if s.options.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.options.BearerToken)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retryable, fmt.Errorf("server returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+}
+
+// promLabel is a single label of a remote-write series.
+type promLabel struct {
+	name  string
+	value string
+}
+
+// promSeries is a remote-write series holding a single sample.
+type promSeries struct {
+	labels []promLabel
+	value  float64
+}
+
+// remoteWriteSeries turns a batch into series named after promFamilies,
+// with labels sorted by name as remote write requires.
+func remoteWriteSeries(batch *models.MetricsBatch) []promSeries {
+	var series []promSeries
+	for _, family := range promFamilies {
+		family.samples(batch, func(labels []string, value float64) {
+			ls := []promLabel{{name: "__name__", value: family.name}}
+			for i := 0; i < len(labels); i += 2 {
+				ls = append(ls, promLabel{name: labels[i], value: labels[i+1]})
+			}
+			sort.Slice(ls, func(i, j int) bool { return ls[i].name < ls[j].name })
+			series = append(series, promSeries{labels: ls, value: value})
+		})
+	}
+	return series
+}
+
+// encodeWriteRequest encodes series as a prometheus.WriteRequest protobuf
+// message, all sampled at timestamp (in milliseconds).
+func encodeWriteRequest(series []promSeries, timestamp int64) []byte {
+	var req, ts, msg []byte
+	for _, s := range series {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			msg = msg[:0]
+			msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.name)
+			msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.value)
+
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, msg)
+		}
+
+		msg = msg[:0]
+		msg = protowire.AppendTag(msg, 1, protowire.Fixed64Type)
+		msg = protowire.AppendFixed64(msg, math.Float64bits(s.value))
+		msg = protowire.AppendTag(msg, 2, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(timestamp))
+
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, msg)
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
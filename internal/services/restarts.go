@@ -0,0 +1,55 @@
+package services
+
+import (
+	"slices"
+	"time"
+
+	"github.com/stenstromen/tinykmetrics/internal/models"
+)
+
+// restartTracker turns the restart counts of containers into restart
+// events. Statuses of a container must be added in time order; the first
+// status of each container only sets its baseline.
+type restartTracker struct {
+	counts map[string]int64
+	events []models.RestartEvent
+}
+
+func newRestartTracker() *restartTracker {
+	return &restartTracker{counts: make(map[string]int64)}
+}
+
+// add records the status of a container at t, reporting a restart if its
+// restart count grew since its previous status.
+func (r *restartTracker) add(t time.Time, status models.ContainerStatusSample) {
+	key := status.Namespace + "/" + status.Pod + "/" + status.Container
+	previous, seen := r.counts[key]
+	r.counts[key] = status.RestartCount
+
+	if seen && status.RestartCount > previous {
+		r.events = append(r.events, models.RestartEvent{
+			Time:         t.UTC(),
+			Namespace:    status.Namespace,
+			Pod:          status.Pod,
+			Container:    status.Container,
+			Restarts:     status.RestartCount - previous,
+			RestartCount: status.RestartCount,
+			Reason:       status.LastTerminationReason,
+			ExitCode:     status.LastExitCode,
+		})
+	}
+}
+
+// result returns the restarts reported so far, oldest first.
+func (r *restartTracker) result() []models.RestartEvent {
+	return sortRestarts(r.events)
+}
+
+// sortRestarts orders events by time, keeping the order of simultaneous
+// events.
+func sortRestarts(events []models.RestartEvent) []models.RestartEvent {
+	slices.SortStableFunc(events, func(a, b models.RestartEvent) int {
+		return a.Time.Compare(b.Time)
+	})
+	return events
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	http2 "github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/stenstromen/tinykmetrics/internal/models"
+)
+
+// Sink receives the samples gathered in each collection cycle. Close is
+// called once on shutdown, after the last Write has returned.
+type Sink interface {
+	Write(ctx context.Context, batch *models.MetricsBatch) error
+	Close() error
+}
+
+// MultiSink fans a batch out to several sinks concurrently.
+type MultiSink []Sink
+
+func (m MultiSink) Write(ctx context.Context, batch *models.MetricsBatch) error {
+	errs := make([]error, len(m))
+
+	var wg sync.WaitGroup
+	for i, sink := range m {
+		wg.Add(1)
+		go func(i int, sink Sink) {
+			defer wg.Done()
+			errs[i] = sink.Write(ctx, batch)
+		}(i, sink)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func (m MultiSink) Close() error {
+	var errs []error
+	for _, sink := range m {
+		errs = append(errs, sink.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// SwitchSink forwards batches to a sink that can be replaced while
+// metrics are being collected.
+type SwitchSink struct {
+	mu   sync.RWMutex
+	sink Sink
+}
+
+func NewSwitchSink(sink Sink) *SwitchSink {
+	return &SwitchSink{sink: sink}
+}
+
+func (s *SwitchSink) Write(ctx context.Context, batch *models.MetricsBatch) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sink.Write(ctx, batch)
+}
+
+func (s *SwitchSink) Close() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sink.Close()
+}
+
+// Swap replaces the sink once any in-flight Write has returned and returns
+// the previous sink, which the caller is responsible for closing.
+func (s *SwitchSink) Swap(sink Sink) Sink {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.sink
+	s.sink = sink
+	return prev
+}
+
+// maxRetryDelay caps the exponential backoff between retries.
+const maxRetryDelay = 30 * time.Second
+
+// retry calls send until it succeeds, reports a permanent failure or
+// maxRetries retries are used up, backing off between attempts. send
+// reports whether its error is worth retrying.
+func retry(ctx context.Context, what string, maxRetries int, interval time.Duration, send func() (bool, error)) error {
+	for attempt := 0; ; attempt++ {
+		retryable, err := send()
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= maxRetries || ctx.Err() != nil {
+			return err
+		}
+
+		delay := retryDelay(interval, attempt, err)
+		log.Printf("Error sending %s (attempt %d/%d), retrying in %v: %v",
+			what, attempt+1, maxRetries+1, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// retryDelay returns the exponential backoff for the given attempt with
+// jitter applied, honoring a Retry-After header when InfluxDB sent one.
+func retryDelay(base time.Duration, attempt int, err error) time.Duration {
+	var httpErr *http2.Error
+	if errors.As(err, &httpErr) && httpErr.RetryAfter > 0 {
+		return time.Duration(httpErr.RetryAfter) * time.Second
+	}
+
+	return backoff(base, attempt)
+}
+
+// backoff doubles base for every attempt, up to maxRetryDelay, and picks
+// a random delay between half and all of it.
+func backoff(base time.Duration, attempt int) time.Duration {
+	delay := base << attempt
+	if delay <= 0 || delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
@@ -0,0 +1,247 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const spoolExt = ".lp"
+
+// Spool is an on-disk buffer of line protocol records that could not be
+// written. Each failed batch is stored as its own segment file, named so
+// that lexical order matches write order, and segments are dropped oldest
+// first once the spool exceeds maxSize bytes or maxAge.
+type Spool struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+
+	mu       sync.Mutex
+	seq      uint64
+	dropped  int64
+	rejected string // segment the last Replay stopped at
+}
+
+func NewSpool(dir string, maxSize int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating spool directory: %v", err)
+	}
+
+	return &Spool{
+		dir:     dir,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+	}, nil
+}
+
+// Append stores lines as a new segment, dropping older segments as needed
+// to stay within the spool limits.
+func (s *Spool) Append(lines []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq%1000000, spoolExt)
+	tmp := filepath.Join(s.dir, name+".tmp")
+
+	data := strings.Join(lines, "\n") + "\n"
+	if err := os.WriteFile(tmp, []byte(data), 0o600); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error writing spool segment: %v", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error writing spool segment: %v", err)
+	}
+
+	return s.prune()
+}
+
+// Dropped returns the number of lines discarded since startup.
+func (s *Spool) Dropped() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Pending reports whether the spool holds any segments.
+func (s *Spool) Pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	segments, err := s.segments()
+	return err == nil && len(segments) > 0
+}
+
+// Replay passes each segment to fn, oldest first, removing it once fn
+// succeeds. It stops at the first error and returns the number of lines
+// replayed so far. The spool is not locked while fn runs, so Append is
+// not held up by a slow replay; segments pruned meanwhile are skipped.
+func (s *Spool) Replay(fn func(lines []string) error) (int, error) {
+	s.mu.Lock()
+	s.rejected = ""
+	err := s.prune()
+	var segments []spoolSegment
+	if err == nil {
+		segments, err = s.segments()
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for _, segment := range segments {
+		s.mu.Lock()
+		lines, err := readSegment(segment.path)
+		s.mu.Unlock()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return replayed, err
+		}
+
+		if err := fn(lines); err != nil {
+			s.mu.Lock()
+			s.rejected = segment.path
+			s.mu.Unlock()
+			return replayed, err
+		}
+
+		s.mu.Lock()
+		err = os.Remove(segment.path)
+		s.mu.Unlock()
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return replayed, fmt.Errorf("error removing spool segment: %v", err)
+		}
+		replayed += len(lines)
+	}
+
+	return replayed, nil
+}
+
+// Discard removes the segment the last Replay stopped at, for records the
+// server rejects permanently.
+func (s *Spool) Discard() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rejected == "" {
+		return nil
+	}
+	segment := spoolSegment{path: s.rejected}
+	s.rejected = ""
+
+	err := s.remove(segment)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+type spoolSegment struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// segments lists the spool segments in write order.
+func (s *Spool) segments() ([]spoolSegment, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading spool directory: %v", err)
+	}
+
+	var segments []spoolSegment
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != spoolExt {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		segments = append(segments, spoolSegment{
+			path:    filepath.Join(s.dir, entry.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i].path < segments[j].path })
+	return segments, nil
+}
+
+// prune drops expired segments and then the oldest ones until the spool
+// fits in maxSize.
+func (s *Spool) prune() error {
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, segment := range segments {
+		total += segment.size
+	}
+
+	for _, segment := range segments {
+		expired := s.maxAge > 0 && time.Since(segment.modTime) > s.maxAge
+		oversized := s.maxSize > 0 && total > s.maxSize
+		if !expired && !oversized {
+			break
+		}
+
+		if err := s.remove(segment); err != nil {
+			return err
+		}
+		total -= segment.size
+	}
+	return nil
+}
+
+// remove deletes a segment and counts its lines as dropped.
+func (s *Spool) remove(segment spoolSegment) error {
+	lines, err := readSegment(segment.path)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(segment.path); err != nil {
+		return fmt.Errorf("error removing spool segment: %v", err)
+	}
+
+	log.Printf("Dropped spool segment %s with %d points", filepath.Base(segment.path), len(lines))
+	s.dropped += int64(len(lines))
+	return nil
+}
+
+func readSegment(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading spool segment: %w", err)
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading spool segment: %v", err)
+	}
+	return lines, nil
+}
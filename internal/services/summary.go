@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stenstromen/tinykmetrics/internal/models"
+)
+
+// SummaryOptions configures collection from the kubelet Summary API. By
+// default each node's summary is read through the API server node proxy;
+// with URL set it is fetched from URL instead, with {node} replaced by the
+// node name.
+type SummaryOptions struct {
+	URL         string
+	Concurrency int
+	Timeout     time.Duration
+}
+
+// kubeletSummary is the part of the kubelet /stats/summary response that
+// is collected. Every value is optional.
+type kubeletSummary struct {
+	Node struct {
+		NodeName string               `json:"nodeName"`
+		Network  *kubeletNetworkStats `json:"network"`
+		Memory   *kubeletMemoryStats  `json:"memory"`
+		Fs       *kubeletFsStats      `json:"fs"`
+		Runtime  *struct {
+			ImageFs *kubeletFsStats `json:"imageFs"`
+		} `json:"runtime"`
+	} `json:"node"`
+	Pods []struct {
+		PodRef struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"podRef"`
+		Containers []struct {
+			Name   string              `json:"name"`
+			Memory *kubeletMemoryStats `json:"memory"`
+			Rootfs *kubeletFsStats     `json:"rootfs"`
+			Logs   *kubeletFsStats     `json:"logs"`
+		} `json:"containers"`
+		Network     *kubeletNetworkStats `json:"network"`
+		VolumeStats []struct {
+			kubeletFsStats
+			Name   string `json:"name"`
+			PVCRef *struct {
+				Name string `json:"name"`
+			} `json:"pvcRef"`
+		} `json:"volume"`
+		EphemeralStorage *kubeletFsStats `json:"ephemeral-storage"`
+	} `json:"pods"`
+}
+
+type kubeletNetworkStats struct {
+	RxBytes  *uint64 `json:"rxBytes"`
+	RxErrors *uint64 `json:"rxErrors"`
+	TxBytes  *uint64 `json:"txBytes"`
+	TxErrors *uint64 `json:"txErrors"`
+}
+
+type kubeletMemoryStats struct {
+	WorkingSetBytes *uint64 `json:"workingSetBytes"`
+	RSSBytes        *uint64 `json:"rssBytes"`
+}
+
+type kubeletFsStats struct {
+	AvailableBytes *uint64 `json:"availableBytes"`
+	CapacityBytes  *uint64 `json:"capacityBytes"`
+	UsedBytes      *uint64 `json:"usedBytes"`
+}
+
+// stat returns *v, or 0 if v is nil.
+func stat(v *uint64) int64 {
+	if v == nil {
+		return 0
+	}
+	return int64(*v)
+}
+
+func (n *kubeletNetworkStats) stats() *models.NetworkStats {
+	if n == nil {
+		return nil
+	}
+	return &models.NetworkStats{
+		RxBytes:  stat(n.RxBytes),
+		RxErrors: stat(n.RxErrors),
+		TxBytes:  stat(n.TxBytes),
+		TxErrors: stat(n.TxErrors),
+	}
+}
+
+func (m *kubeletMemoryStats) workingSet() int64 {
+	if m == nil {
+		return 0
+	}
+	return stat(m.WorkingSetBytes)
+}
+
+func (m *kubeletMemoryStats) rss() int64 {
+	if m == nil {
+		return 0
+	}
+	return stat(m.RSSBytes)
+}
+
+func (f *kubeletFsStats) used() int64 {
+	if f == nil {
+		return 0
+	}
+	return stat(f.UsedBytes)
+}
+
+func (f *kubeletFsStats) capacity() int64 {
+	if f == nil {
+		return 0
+	}
+	return stat(f.CapacityBytes)
+}
+
+// SetSummaryOptions enables collection from the kubelet Summary API,
+// starting with the next collection. Nil disables it.
+func (s *KubernetesService) SetSummaryOptions(options *SummaryOptions) {
+	s.mu.Lock()
+	s.summary = options
+	s.mu.Unlock()
+}
+
+func (s *KubernetesService) summaryOptions() *SummaryOptions {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.summary
+}
+
+// collectSummaries reads the summary of every node in batch and adds the
+// statistics of the node, of the containers and pods already in batch and
+// of their persistent volumes. Nodes whose summary cannot be read are
+// logged and skipped.
+func (s *KubernetesService) collectSummaries(ctx context.Context, options *SummaryOptions, batch *models.MetricsBatch) {
+	summaries := make([]*kubeletSummary, len(batch.Nodes))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(options.Concurrency, 1))
+	for i, node := range batch.Nodes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			summary, err := s.fetchSummary(ctx, options, node.Node)
+			if err != nil {
+				log.Printf("Error getting kubelet summary of node %s: %v", node.Node, err)
+				return
+			}
+			summaries[i] = summary
+		}()
+	}
+	wg.Wait()
+
+	// Only pods whose usage was collected, so the collection filter applies
+	containers := make(map[string]int)
+	pods := make(map[string]int)
+	for i, c := range batch.Containers {
+		containers[c.Namespace+"/"+c.Pod+"/"+c.Container] = i
+		if _, ok := pods[c.Namespace+"/"+c.Pod]; !ok {
+			pods[c.Namespace+"/"+c.Pod] = i
+		}
+	}
+
+	for i, summary := range summaries {
+		if summary == nil {
+			continue
+		}
+
+		node := summary.Node
+		stats := &models.NodeStats{
+			Network:          node.Network.stats(),
+			MemoryWorkingSet: node.Memory.workingSet(),
+			MemoryRSS:        node.Memory.rss(),
+			RootfsUsed:       node.Fs.used(),
+			RootfsCapacity:   node.Fs.capacity(),
+		}
+		if node.Runtime != nil {
+			stats.ImagefsUsed = node.Runtime.ImageFs.used()
+			stats.ImagefsCapacity = node.Runtime.ImageFs.capacity()
+		}
+		batch.Nodes[i].Stats = stats
+
+		for _, pod := range summary.Pods {
+			key := pod.PodRef.Namespace + "/" + pod.PodRef.Name
+			first, ok := pods[key]
+			if !ok {
+				continue
+			}
+
+			for _, c := range pod.Containers {
+				if j, ok := containers[key+"/"+c.Name]; ok {
+					batch.Containers[j].Stats = &models.ContainerStats{
+						MemoryWorkingSet: c.Memory.workingSet(),
+						MemoryRSS:        c.Memory.rss(),
+						RootfsUsed:       c.Rootfs.used(),
+						LogsUsed:         c.Logs.used(),
+					}
+				}
+			}
+
+			owner := batch.Containers[first]
+			batch.Pods = append(batch.Pods, models.PodSample{
+				Namespace:            owner.Namespace,
+				Pod:                  owner.Pod,
+				WorkloadKind:         owner.WorkloadKind,
+				WorkloadName:         owner.WorkloadName,
+				Tags:                 owner.Tags,
+				Network:              pod.Network.stats(),
+				EphemeralStorageUsed: pod.EphemeralStorage.used(),
+			})
+
+			for _, volume := range pod.VolumeStats {
+				if volume.PVCRef == nil {
+					continue
+				}
+				batch.Volumes = append(batch.Volumes, models.VolumeSample{
+					Namespace: owner.Namespace,
+					Pod:       owner.Pod,
+					Volume:    volume.Name,
+					PVC:       volume.PVCRef.Name,
+					Used:      volume.used(),
+					Capacity:  volume.capacity(),
+					Available: stat(volume.AvailableBytes),
+				})
+			}
+		}
+	}
+}
+
+// fetchSummary reads the kubelet summary of node.
+func (s *KubernetesService) fetchSummary(ctx context.Context, options *SummaryOptions, node string) (*kubeletSummary, error) {
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
+
+	var data []byte
+	var err error
+	switch {
+	case options.URL != "":
+		data, err = getSummary(ctx, strings.ReplaceAll(options.URL, "{node}", url.PathEscape(node)))
+	case s.client != nil:
+		data, err = s.client.CoreV1().RESTClient().Get().
+			Resource("nodes").Name(node).SubResource("proxy").Suffix("stats/summary").
+			DoRaw(ctx)
+	default:
+		err = errors.New("no summary URL configured")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &kubeletSummary{}
+	if err := json.Unmarshal(data, summary); err != nil {
+		return nil, fmt.Errorf("error decoding summary: %v", err)
+	}
+	return summary, nil
+}
+
+// getSummary fetches a summary from a kubelet or a stand-in for one.
+func getSummary(ctx context.Context, summaryURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, summaryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/stenstromen/tinykmetrics/internal/models"
+)
+
+// OtherTagValue replaces the values of a tag beyond its limit.
+const OtherTagValue = "_other"
+
+// tagValueTTL is how long a tag value that is no longer seen keeps its
+// place within the limit.
+const tagValueTTL = time.Hour
+
+// containerLabels returns the tags of a container as name/value pairs:
+// namespace, pod and container, the workload if the pod has one and the
+// copied labels and annotations sorted by name.
+func containerLabels(c models.ContainerSample) []string {
+	return workloadLabels([]string{"namespace", c.Namespace, "pod", c.Pod, "container", c.Container},
+		c.WorkloadKind, c.WorkloadName, c.Tags)
+}
+
+// podLabels returns the tags of a pod like containerLabels, without the
+// container.
+func podLabels(p models.PodSample) []string {
+	return workloadLabels([]string{"namespace", p.Namespace, "pod", p.Pod}, p.WorkloadKind, p.WorkloadName, p.Tags)
+}
+
+// statusLabels returns the tags of a container status like
+// containerLabels.
+func statusLabels(s models.ContainerStatusSample) []string {
+	return workloadLabels([]string{"namespace", s.Namespace, "pod", s.Pod, "container", s.Container},
+		s.WorkloadKind, s.WorkloadName, s.Tags)
+}
+
+// podStatusLabels returns the tags of the pod of a container status like
+// podLabels.
+func podStatusLabels(s models.ContainerStatusSample) []string {
+	return workloadLabels([]string{"namespace", s.Namespace, "pod", s.Pod}, s.WorkloadKind, s.WorkloadName, s.Tags)
+}
+
+// volumeLabels returns the tags of a volume as name/value pairs.
+func volumeLabels(v models.VolumeSample) []string {
+	return []string{"namespace", v.Namespace, "pod", v.Pod, "volume", v.Volume, "persistentvolumeclaim", v.PVC}
+}
+
+// eventLabels returns the tags of an event as name/value pairs: the
+// namespace, kind and name of the involved object, and the type and
+// reason. Cluster-scoped objects have no namespace.
+func eventLabels(e models.EventSample) []string {
+	labels := []string{"kind", e.Kind, "name", e.Name, "type", e.Type, "reason", e.Reason}
+	if e.Namespace != "" {
+		labels = append([]string{"namespace", e.Namespace}, labels...)
+	}
+	return labels
+}
+
+// workloadLabels appends the workload and tags to labels.
+func workloadLabels(labels []string, kind, name string, tags map[string]string) []string {
+	if kind != "" {
+		labels = append(labels, "workload_kind", kind, "workload_name", name)
+	}
+	for _, tag := range slices.Sorted(maps.Keys(tags)) {
+		labels = append(labels, tag, tags[tag])
+	}
+	return labels
+}
+
+// nodeLabels returns the tags of a node as name/value pairs: node, and
+// role, zone and instance_type when set.
+func nodeLabels(n models.NodeSample) []string {
+	labels := []string{"node", n.Node}
+	for _, tag := range []struct{ name, value string }{
+		{"role", n.Role},
+		{"zone", n.Zone},
+		{"instance_type", n.InstanceType},
+	} {
+		if tag.value != "" {
+			labels = append(labels, tag.name, tag.value)
+		}
+	}
+	return labels
+}
+
+// PodTagger copies allow-listed pod labels and annotations into tags. To
+// bound the number of series, each tag keeps at most maxValues distinct
+// values; further values are written as OtherTagValue until a value has
+// not been seen for tagValueTTL.
+type PodTagger struct {
+	labels      map[string]string // label key to tag name
+	annotations map[string]string // annotation key to tag name
+	maxValues   int
+
+	mu      sync.Mutex
+	values  map[string]map[string]time.Time // tag name to value to last seen
+	evicted map[string]time.Time            // tag name to last eviction
+}
+
+func NewPodTagger(labels, annotations map[string]string, maxValues int) *PodTagger {
+	return &PodTagger{
+		labels:      labels,
+		annotations: annotations,
+		maxValues:   maxValues,
+		values:      make(map[string]map[string]time.Time),
+		evicted:     make(map[string]time.Time),
+	}
+}
+
+// Tags returns the tags of a pod collected at now. A nil tagger returns
+// no tags.
+func (t *PodTagger) Tags(podLabels, podAnnotations map[string]string, now time.Time) map[string]string {
+	if t == nil || len(t.labels)+len(t.annotations) == 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tags := make(map[string]string)
+	for _, source := range []struct {
+		keys   map[string]string
+		values map[string]string
+	}{
+		{t.labels, podLabels},
+		{t.annotations, podAnnotations},
+	} {
+		for key, tag := range source.keys {
+			if value, ok := source.values[key]; ok && value != "" {
+				tags[tag] = t.limit(tag, value, now)
+			}
+		}
+	}
+	return tags
+}
+
+// limit returns value, or OtherTagValue if tag already has maxValues
+// other values.
+func (t *PodTagger) limit(tag, value string, now time.Time) string {
+	if t.maxValues <= 0 {
+		return value
+	}
+
+	values, ok := t.values[tag]
+	if !ok {
+		values = make(map[string]time.Time)
+		t.values[tag] = values
+	}
+
+	if _, ok := values[value]; !ok && len(values) >= t.maxValues {
+		// Make room by forgetting stale values, once per collection
+		if !t.evicted[tag].Equal(now) {
+			t.evicted[tag] = now
+			for v, seen := range values {
+				if now.Sub(seen) > tagValueTTL {
+					delete(values, v)
+				}
+			}
+		}
+		if len(values) >= t.maxValues {
+			return OtherTagValue
+		}
+	}
+
+	values[value] = now
+	return value
+}
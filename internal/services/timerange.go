@@ -0,0 +1,118 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidQuery is wrapped by errors caused by malformed query input.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// TimeRange is an absolute query window.
+type TimeRange struct {
+	Start time.Time
+	Stop  time.Time
+}
+
+// durationUnits are the Flux duration units accepted in relative ranges.
+var durationUnits = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"µs": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+}
+
+// ParseTimeRange resolves start and stop into an absolute window. Each may
+// be a relative duration such as "15m", "2d" or "1h30m", counted back from
+// now, or an RFC3339 timestamp. An empty stop means now.
+func ParseTimeRange(start, stop string, now time.Time) (TimeRange, error) {
+	if start == "" {
+		return TimeRange{}, fmt.Errorf("%w: start is required", ErrInvalidQuery)
+	}
+
+	r := TimeRange{Stop: now}
+
+	var err error
+	if r.Start, err = parseTime(start, now); err != nil {
+		return TimeRange{}, fmt.Errorf("%w: start: %v", ErrInvalidQuery, err)
+	}
+	if stop != "" {
+		if r.Stop, err = parseTime(stop, now); err != nil {
+			return TimeRange{}, fmt.Errorf("%w: stop: %v", ErrInvalidQuery, err)
+		}
+	}
+
+	if !r.Start.Before(r.Stop) {
+		return TimeRange{}, fmt.Errorf("%w: start must be before stop", ErrInvalidQuery)
+	}
+
+	return r, nil
+}
+
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+
+	d, err := parseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a duration such as 15m or 2d nor an RFC3339 timestamp", value)
+	}
+	return now.Add(-d), nil
+}
+
+// parseDuration parses a positive Flux-style duration, optionally prefixed
+// with "-", such as "90s", "2d" or "1w2d".
+func parseDuration(value string) (time.Duration, error) {
+	s := strings.TrimPrefix(value, "-")
+	if s == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+
+	var total time.Duration
+	for s != "" {
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == 0 {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		n, err := strconv.ParseInt(s[:i], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		s = s[i:]
+
+		j := 0
+		for j < len(s) && (s[j] < '0' || s[j] > '9') {
+			j++
+		}
+		unit, ok := durationUnits[s[:j]]
+		if !ok {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		s = s[j:]
+
+		if n > int64(time.Duration(1<<63-1)/unit) {
+			return 0, fmt.Errorf("duration %q is too large", value)
+		}
+		total += time.Duration(n) * unit
+		if total < 0 {
+			return 0, fmt.Errorf("duration %q is too large", value)
+		}
+	}
+
+	if total == 0 {
+		return 0, fmt.Errorf("duration %q must be positive", value)
+	}
+	return total, nil
+}
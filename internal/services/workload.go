@@ -0,0 +1,179 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/stenstromen/tinykmetrics/internal/models"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// workloadFields are the pod_metrics fields rolled up per workload.
+// Utilizations do not add up and are left out.
+var workloadFields = []string{"cpu_usage", "memory_usage", "cpu_request", "cpu_limit", "memory_request", "memory_limit"}
+
+// kindPattern matches a resource kind such as StatefulSet.
+var kindPattern = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+
+// ListWorkloads returns the workloads owning the pods metrics are
+// collected for, in namespace or in all namespaces if it is empty, with
+// their number of pods. Bare pods have no workload and are left out.
+func (s *KubernetesService) ListWorkloads(ctx context.Context, namespace string) ([]models.Workload, error) {
+	type workload struct{ namespace, kind, name string }
+	pods := make(map[workload]int)
+
+	if s.client == nil {
+		mockPods, err := s.ListPods(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range mockPods {
+			owner := mockPodWorkloads[pod.Name]
+			pods[workload{pod.Namespace, owner[0], owner[1]}]++
+		}
+	} else {
+		if !s.HasSynced() {
+			return nil, ErrCacheNotSynced
+		}
+
+		filter := s.collectionFilter()
+		var podList []*corev1.Pod
+		var err error
+		if namespace != "" {
+			podList, err = s.podLister.Pods(namespace).List(filter.Selector())
+		} else {
+			podList, err = s.podLister.List(filter.Selector())
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pod := range podList {
+			if !filter.MatchNamespace(pod.Namespace) {
+				continue
+			}
+			if kind, name := s.podWorkload(pod); kind != "" {
+				pods[workload{pod.Namespace, kind, name}]++
+			}
+		}
+	}
+
+	workloads := make([]models.Workload, 0, len(pods))
+	for w, count := range pods {
+		workloads = append(workloads, models.Workload{Namespace: w.namespace, Kind: w.kind, Name: w.name, Pods: count})
+	}
+	slices.SortFunc(workloads, func(a, b models.Workload) int {
+		return cmp.Or(cmp.Compare(a.Namespace, b.Namespace), cmp.Compare(a.Kind, b.Kind), cmp.Compare(a.Name, b.Name))
+	})
+	return workloads, nil
+}
+
+// isWorkloadQuery reports whether query selects a workload rather than
+// pods.
+func isWorkloadQuery(query models.MetricsQuery) bool {
+	return query.WorkloadKind != "" || query.WorkloadName != "" || query.Rollup != ""
+}
+
+// parseWorkloadQuery validates the workload selected by query and returns
+// the function rolling up its pods, sum or mean.
+func parseWorkloadQuery(query models.MetricsQuery) (string, error) {
+	if query.Namespace == "" || query.WorkloadKind == "" || query.WorkloadName == "" {
+		return "", fmt.Errorf("%w: a workload query needs namespace, workload_kind and workload_name", ErrInvalidQuery)
+	}
+	if query.Pod != "" {
+		return "", fmt.Errorf("%w: pod and workload_name are mutually exclusive", ErrInvalidQuery)
+	}
+	if err := validateWorkload(query.Namespace, query.WorkloadKind, query.WorkloadName); err != nil {
+		return "", err
+	}
+
+	switch query.Rollup {
+	case "", "sum":
+		return "sum", nil
+	case "mean":
+		return "mean", nil
+	default:
+		return "", fmt.Errorf("%w: rollup %q must be sum or mean", ErrInvalidQuery, query.Rollup)
+	}
+}
+
+// validateWorkload rejects anything that is not a valid namespace, kind
+// and object name.
+func validateWorkload(namespace, kind, name string) error {
+	if err := validateNamespace(namespace); err != nil {
+		return err
+	}
+	if !kindPattern.MatchString(kind) {
+		return fmt.Errorf("%w: workload_kind %q must be a kind such as Deployment", ErrInvalidQuery, kind)
+	}
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return fmt.Errorf("%w: workload_name %q: %s", ErrInvalidQuery, name, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// rollupWorkload turns the aggregated container points of a workload into
+// workload points: each window is summed per pod, then the pods are summed
+// or averaged with fn. Points are ordered by field and time and tagged
+// with the namespace and workload instead of the pod and container.
+func rollupWorkload(points []map[string]interface{}, fn, namespace, kind, name string) []map[string]interface{} {
+	type podWindow struct {
+		field, pod string
+		time       int64
+	}
+	type window struct {
+		field string
+		time  int64
+	}
+
+	pods := make(map[podWindow]float64)
+	for _, point := range points {
+		field, _ := point["field"].(string)
+		pod, _ := point["pod"].(string)
+		t, ok := point["time"].(time.Time)
+		value, ok2 := point["value"].(float64)
+		if !ok || !ok2 || !slices.Contains(workloadFields, field) {
+			continue
+		}
+		pods[podWindow{field, pod, t.UnixNano()}] += value
+	}
+
+	sums := make(map[window]float64)
+	counts := make(map[window]int)
+	for key, value := range pods {
+		w := window{key.field, key.time}
+		sums[w] += value
+		counts[w]++
+	}
+
+	windows := make([]window, 0, len(sums))
+	for w := range sums {
+		windows = append(windows, w)
+	}
+	slices.SortFunc(windows, func(a, b window) int {
+		return cmp.Or(strings.Compare(a.field, b.field), cmp.Compare(a.time, b.time))
+	})
+
+	rolled := make([]map[string]interface{}, 0, len(windows))
+	for _, w := range windows {
+		value := sums[w]
+		if fn == "mean" {
+			value /= float64(counts[w])
+		}
+		rolled = append(rolled, map[string]interface{}{
+			"time":          time.Unix(0, w.time).UTC(),
+			"value":         value,
+			"field":         w.field,
+			"namespace":     namespace,
+			"workload_kind": kind,
+			"workload_name": name,
+		})
+	}
+	return rolled
+}